@@ -0,0 +1,75 @@
+package migrate
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/johngibb/migrate/db"
+	"github.com/johngibb/migrate/source"
+)
+
+// Baseline records every versioned migration up to and including the
+// target, which may be either a version or a name, as applied, without
+// executing them. This is meant for adopting migrate on an existing
+// database, whose schema already reflects those migrations. The records
+// are flagged as baselined, and migrations that have already been applied
+// are skipped.
+func Baseline(ctx context.Context, src *source.Source, client db.Driver, target string) (err error) {
+	migrations, err := findMigrations(src)
+	if err != nil {
+		return errors.Wrap(err, "error reading migration files")
+	}
+	if migrations, err = truncateAt(migrations, target); err != nil {
+		return err
+	}
+
+	if err := lock(ctx, client, 0, &TextSink{Logger: DefaultLogger}); err != nil {
+		return err
+	}
+	defer func() {
+		_, e := client.Unlock(ctx)
+		if err == nil && e != nil {
+			err = e
+		}
+	}()
+
+	applied, err := client.GetMigrations(ctx)
+	if err != nil {
+		return errors.Wrap(err, "error fetching migrations")
+	}
+	recorded := make(map[string]*db.Migration)
+	for _, a := range applied {
+		recorded[a.Name] = a
+	}
+
+	n := 0
+	for _, m := range migrations {
+		if m.Repeatable {
+			continue // applied by the next up, as usual
+		}
+		if a := recorded[m.Name]; a != nil {
+			if a.Failed {
+				return errors.Errorf("migration %s previously failed; resolve it before baselining", m.Name)
+			}
+			continue
+		}
+		checksum, err := m.checksum()
+		if err != nil {
+			return errors.Wrap(err, "error reading migration")
+		}
+		record := newRecord(m.Name, checksum, time.Now(), 0)
+		record.Baselined = true
+		if err := client.LogCompletedMigration(ctx, record); err != nil {
+			return errors.Wrap(err, "error recording migration")
+		}
+		log.Printf("Baselined %s", m.Name)
+		n++
+	}
+	if n == 0 {
+		log.Println("nothing to do")
+	}
+	return nil
+}
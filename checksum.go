@@ -0,0 +1,43 @@
+package migrate
+
+import (
+	"github.com/johngibb/migrate/db"
+)
+
+// modification is an applied migration whose source file no longer
+// matches the checksum recorded when it was applied.
+type modification struct {
+	migration *migration
+	checksum  string // current checksum of the source file
+}
+
+// findModified returns the applied migrations whose source has been
+// edited since they were applied. Migrations recorded without a checksum
+// (by older versions of migrate), and repeatable migrations, are never
+// considered modified.
+func findModified(migrations []*migration, applied []*db.Migration) ([]modification, error) {
+	recorded := make(map[string]string)
+	for _, a := range applied {
+		if !a.Failed {
+			recorded[a.Name] = a.Checksum
+		}
+	}
+	var result []modification
+	for _, m := range migrations {
+		if m.Repeatable {
+			continue // changes are expected, and applied by Up
+		}
+		want, ok := recorded[m.Name]
+		if !ok || want == "" {
+			continue
+		}
+		got, err := m.checksum()
+		if err != nil {
+			return nil, err
+		}
+		if got != want {
+			result = append(result, modification{migration: m, checksum: got})
+		}
+	}
+	return result, nil
+}
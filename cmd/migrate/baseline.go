@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/google/subcommands"
+
+	"github.com/johngibb/migrate"
+)
+
+type Baseline struct {
+	dbFlags
+	srcFlags
+	to string
+}
+
+func (*Baseline) Name() string     { return "baseline" }
+func (*Baseline) Synopsis() string { return "mark the migrations of an existing database as applied" }
+func (*Baseline) Usage() string {
+	return `migrate baseline -src <migrations folder> -conn <connection string> -to <version>:
+    Record every migration up to and including the given version or name
+    as applied, without executing them, e.g. when adopting migrate on an
+    existing database whose schema already reflects them.
+
+    Baselined migrations are flagged as such by status. Migrations that
+    have already been applied are skipped.
+` + configUsage
+}
+
+func (cmd *Baseline) SetFlags(f *flag.FlagSet) {
+	cmd.dbFlags.register(f)
+	cmd.srcFlags.register(f)
+	f.StringVar(&cmd.to, "to", "", "version or name of the last migration to baseline")
+}
+
+func (cmd *Baseline) Execute(ctx context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	if cmd.to == "" {
+		fmt.Fprint(os.Stderr, "error: -to is required\n")
+		f.Usage()
+		return subcommands.ExitUsageError
+	}
+	src, err := cmd.open()
+	must(err)
+	db, err := cmd.connect(ctx)
+	must(err)
+	defer db.Close(ctx)
+	must(migrate.Baseline(ctx, src, db, cmd.to))
+	return subcommands.ExitSuccess
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+
+	"github.com/BurntSushi/toml"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// configFiles are the config files looked for in the current directory,
+// unless -config is given.
+var configFiles = []string{"migrate.yaml", ".migrate.toml"}
+
+// settingEnvVars are the environment variables each setting flag is read
+// from, in order of precedence.
+var settingEnvVars = map[string][]string{
+	"conn":   {"MIGRATE_CONN", "DATABASE_URL"},
+	"src":    {"MIGRATE_SRC"},
+	"schema": {"MIGRATE_SCHEMA"},
+	"table":  {"MIGRATE_TABLE"},
+}
+
+// configUsage documents where settings come from, for commands' Usage.
+const configUsage = `
+    The -conn, -src, -schema, and -table settings may instead be given by
+    environment variables, or in a config file, migrate.yaml or
+    .migrate.toml in the current directory, or as given by -config. The
+    config file may define named environments, selected with -env (or
+    MIGRATE_ENV). Each setting is taken from the first of:
+
+      1. the flag, if given
+      2. the -env environment's section of the config file
+      3. MIGRATE_CONN (or DATABASE_URL), MIGRATE_SRC, MIGRATE_SCHEMA, or
+         MIGRATE_TABLE
+      4. the top level of the config file
+      5. the flag's default
+`
+
+// srcConfigUsage is like configUsage, for commands that only take -src.
+const srcConfigUsage = `
+    The -src setting may instead be given by MIGRATE_SRC, or in a config
+    file, migrate.yaml or .migrate.toml in the current directory, or as
+    given by -config. The config file may define named environments,
+    selected with -env (or MIGRATE_ENV). The setting is taken from the
+    first of:
+
+      1. the flag, if given
+      2. the -env environment's section of the config file
+      3. MIGRATE_SRC
+      4. the top level of the config file
+      5. the flag's default
+`
+
+// settings are the settings that may be given by a config file.
+type settings struct {
+	Conn   string `yaml:"conn" toml:"conn"`
+	Src    string `yaml:"src" toml:"src"`
+	Schema string `yaml:"schema" toml:"schema"`
+	Table  string `yaml:"table" toml:"table"`
+}
+
+// get returns the named setting, e.g. "conn".
+func (s *settings) get(name string) string {
+	switch name {
+	case "conn":
+		return s.Conn
+	case "src":
+		return s.Src
+	case "schema":
+		return s.Schema
+	case "table":
+		return s.Table
+	}
+	return ""
+}
+
+// config is a config file, e.g.:
+//
+//	src: ./migrations
+//	environments:
+//	  staging:
+//	    conn: ${STAGING_DATABASE_URL}
+//	  production:
+//	    conn: ${PRODUCTION_DATABASE_URL}
+//	    schema: app
+//
+// ${VAR} references to environment variables are expanded, so that
+// passwords needn't be written in the file.
+type config struct {
+	settings     `yaml:",inline"`
+	Environments map[string]*settings `yaml:"environments" toml:"environments"`
+}
+
+// registerConfigFlags registers the -env and -config flags, unless
+// they're already registered, since they're shared by dbFlags and
+// srcFlags.
+func registerConfigFlags(f *flag.FlagSet) {
+	if f.Lookup("env") != nil {
+		return
+	}
+	f.String("env", os.Getenv("MIGRATE_ENV"), "environment in the config file to use settings from")
+	f.String("config", "", "config file (default: migrate.yaml or .migrate.toml, if present)")
+}
+
+// applyConfig sets each setting flag in f that wasn't given on the
+// command line, if any, from the environment or config file, in order of
+// precedence.
+func applyConfig(f *flag.FlagSet) error {
+	if f == nil || f.Lookup("env") == nil {
+		return nil
+	}
+	given := make(map[string]bool)
+	f.Visit(func(fl *flag.Flag) { given[fl.Name] = true })
+
+	cfg, err := loadConfig(f.Lookup("config").Value.String())
+	if err != nil {
+		return err
+	}
+	var env *settings
+	if name := f.Lookup("env").Value.String(); name != "" {
+		if cfg == nil {
+			return errors.Errorf("environment %s: no config file found", name)
+		}
+		if env = cfg.Environments[name]; env == nil {
+			return errors.Errorf("environment %s is not defined in the config file", name)
+		}
+	}
+
+	names := make([]string, 0, len(settingEnvVars))
+	for name := range settingEnvVars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if f.Lookup(name) == nil || given[name] {
+			continue
+		}
+		if v := lookupSetting(name, env, cfg); v != "" {
+			if err := f.Set(name, v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// lookupSetting returns the named setting from the environment section
+// of the config file, environment variables, or the top level of the
+// config file, in that order, or "" if it isn't set.
+func lookupSetting(name string, env *settings, cfg *config) string {
+	if env != nil {
+		if v := env.get(name); v != "" {
+			return v
+		}
+	}
+	for _, key := range settingEnvVars[name] {
+		if v := os.Getenv(key); v != "" {
+			return v
+		}
+	}
+	if cfg != nil {
+		return cfg.get(name)
+	}
+	return ""
+}
+
+// loadConfig loads the config file at path or, if path is empty, the
+// first of configFiles that exists, if any. It returns nil if there's no
+// config file.
+func loadConfig(path string) (*config, error) {
+	if path == "" {
+		for _, name := range configFiles {
+			if _, err := os.Stat(name); err == nil {
+				path = name
+				break
+			}
+		}
+		if path == "" {
+			return nil, nil
+		}
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read config file")
+	}
+
+	var cfg config
+	switch ext := filepath.Ext(path); ext {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(b))
+		dec.KnownFields(true)
+		if err := dec.Decode(&cfg); err != nil && err != io.EOF { // EOF if empty
+			return nil, errors.Wrapf(err, "could not parse %s", path)
+		}
+	case ".toml":
+		md, err := toml.Decode(string(b), &cfg)
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not parse %s", path)
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			return nil, errors.Errorf("could not parse %s: unknown setting %s", path, undecoded[0])
+		}
+	default:
+		return nil, errors.Errorf("unsupported config file type: %s", path)
+	}
+	cfg.expandEnv()
+	for _, env := range cfg.Environments {
+		if env != nil {
+			env.expandEnv()
+		}
+	}
+	return &cfg, nil
+}
+
+// envRef matches a ${VAR} reference to an environment variable. Bare $VAR
+// references aren't expanded, since passwords may contain $.
+var envRef = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandEnv expands the ${VAR} references in the settings.
+func (s *settings) expandEnv() {
+	for _, v := range []*string{&s.Conn, &s.Src, &s.Schema, &s.Table} {
+		*v = envRef.ReplaceAllStringFunc(*v, func(ref string) string {
+			return os.Getenv(envRef.FindStringSubmatch(ref)[1])
+		})
+	}
+}
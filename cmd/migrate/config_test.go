@@ -0,0 +1,133 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+const testYAML = `
+conn: postgres://localhost/dev
+src: ./migrations
+environments:
+  staging:
+    conn: ${TEST_STAGING_URL}
+    schema: app
+`
+
+const testTOML = `
+conn = "postgres://localhost/dev"
+src = "./migrations"
+
+[environments.staging]
+conn = "${TEST_STAGING_URL}"
+schema = "app"
+`
+
+func TestApplyConfig(t *testing.T) {
+	dir := t.TempDir()
+	for name, src := range map[string]string{"migrate.yaml": testYAML, ".migrate.toml": testTOML} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		name string
+		args []string
+		env  map[string]string
+		want dbFlags
+		src  string
+	}{
+		{
+			name: "config file",
+			want: dbFlags{conn: "postgres://localhost/dev", table: "migrations"},
+			src:  "./migrations",
+		},
+		{
+			name: "environment variables override config file",
+			env:  map[string]string{"DATABASE_URL": "postgres://db/url", "MIGRATE_SRC": "./sql"},
+			want: dbFlags{conn: "postgres://db/url", table: "migrations"},
+			src:  "./sql",
+		},
+		{
+			name: "MIGRATE_CONN overrides DATABASE_URL",
+			env:  map[string]string{"DATABASE_URL": "postgres://db/url", "MIGRATE_CONN": "postgres://migrate/conn"},
+			want: dbFlags{conn: "postgres://migrate/conn", table: "migrations"},
+			src:  "./migrations",
+		},
+		{
+			name: "environment section overrides environment variables",
+			args: []string{"-env", "staging"},
+			env:  map[string]string{"MIGRATE_CONN": "postgres://migrate/conn", "TEST_STAGING_URL": "postgres://staging/app"},
+			want: dbFlags{conn: "postgres://staging/app", schema: "app", table: "migrations"},
+			src:  "./migrations",
+		},
+		{
+			name: "flags override everything",
+			args: []string{"-env", "staging", "-conn", "postgres://flag/conn", "-src", "./flag"},
+			env:  map[string]string{"MIGRATE_CONN": "postgres://migrate/conn"},
+			want: dbFlags{conn: "postgres://flag/conn", schema: "app", table: "migrations"},
+			src:  "./flag",
+		},
+	}
+	for _, config := range []string{"migrate.yaml", ".migrate.toml"} {
+		for _, tt := range tests {
+			for _, k := range []string{"MIGRATE_CONN", "DATABASE_URL", "MIGRATE_SRC", "MIGRATE_ENV", "TEST_STAGING_URL"} {
+				t.Setenv(k, "")
+			}
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			f := flag.NewFlagSet("test", flag.ContinueOnError)
+			var (
+				d dbFlags
+				s srcFlags
+			)
+			d.register(f)
+			s.register(f)
+			args := append([]string{"-config", filepath.Join(dir, config)}, tt.args...)
+			if err := f.Parse(args); err != nil {
+				t.Fatal(err)
+			}
+			if err := applyConfig(f); err != nil {
+				t.Errorf("%s: %s: %v", config, tt.name, err)
+				continue
+			}
+			d.flags = nil
+			if d != tt.want || s.srcPath != tt.src {
+				t.Errorf("%s: %s: got %+v and src %q, want %+v and src %q", config, tt.name, d, s.srcPath, tt.want, tt.src)
+			}
+		}
+	}
+}
+
+func TestApplyConfigErrors(t *testing.T) {
+	t.Setenv("MIGRATE_ENV", "")
+	dir := t.TempDir()
+	for name, src := range map[string]string{
+		"migrate.yaml": testYAML,
+		"typo.yaml":    "con: postgres://localhost/dev\n",
+		"typo.toml":    "con = \"postgres://localhost/dev\"\n",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, args := range [][]string{
+		{"-config", filepath.Join(dir, "migrate.yaml"), "-env", "production"},
+		{"-config", filepath.Join(dir, "missing.yaml")},
+		{"-config", filepath.Join(dir, "typo.yaml")},
+		{"-config", filepath.Join(dir, "typo.toml")},
+	} {
+		f := flag.NewFlagSet("test", flag.ContinueOnError)
+		var d dbFlags
+		d.register(f)
+		if err := f.Parse(args); err != nil {
+			t.Fatal(err)
+		}
+		if err := applyConfig(f); err == nil {
+			t.Errorf("%v: want error, got nil", args)
+		}
+	}
+}
@@ -20,11 +20,12 @@ func (*Create) Synopsis() string { return "create new migration file" }
 func (*Create) Usage() string {
 	return `migrate create -src <folder> <migration name>:
     Creates a new migration file.
-`
+` + srcConfigUsage
 }
 
 func (cmd *Create) SetFlags(f *flag.FlagSet) {
 	f.StringVar(&cmd.srcPath, "src", ".", "directory containing migration files")
+	registerConfigFlags(f)
 }
 
 func (cmd *Create) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
@@ -33,6 +34,7 @@ func (cmd *Create) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{})
 		f.Usage()
 		return subcommands.ExitUsageError
 	}
+	must(applyConfig(f))
 	src, err := source.New(cmd.srcPath)
 	must(err)
 	must(migrate.Create(src, f.Arg(0)))
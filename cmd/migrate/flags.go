@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/johngibb/migrate/db"
+	"github.com/johngibb/migrate/source"
+)
+
+// dbFlags are the flags shared by every command that connects to the
+// database.
+type dbFlags struct {
+	flags  *flag.FlagSet
+	conn   string
+	schema string
+	table  string
+}
+
+func (d *dbFlags) register(f *flag.FlagSet) {
+	d.flags = f
+	registerConfigFlags(f)
+	f.StringVar(&d.conn, "conn", "", "connection string, for postgres or sqlite://<path>")
+	f.StringVar(&d.schema, "schema", "", "schema containing the migrations table (default: search_path)")
+	f.StringVar(&d.table, "table", db.DefaultTable, "name of the migrations table")
+}
+
+// connect connects to the database specified by the flags, or else by
+// the environment or config file.
+func (d *dbFlags) connect(ctx context.Context) (db.Driver, error) {
+	if err := applyConfig(d.flags); err != nil {
+		return nil, err
+	}
+	return db.Open(ctx, d.conn, db.Options{
+		Schema: d.schema,
+		Table:  d.table,
+	})
+}
+
+// srcFlags are the flags shared by every command that reads migration
+// files.
+type srcFlags struct {
+	flags     *flag.FlagSet
+	srcPath   string
+	recursive bool
+	vars      varsFlag
+	varsFile  string
+}
+
+func (s *srcFlags) register(f *flag.FlagSet) {
+	s.flags = f
+	registerConfigFlags(f)
+	f.StringVar(&s.srcPath, "src", ".", "directory containing migration files")
+	f.BoolVar(&s.recursive, "recursive", false, "also search subdirectories of -src for migration files")
+	f.Var(&s.vars, "var", "variable for template migration files, as key=value (repeatable)")
+	f.StringVar(&s.varsFile, "vars", "", "file of variables for template migration files, one key=value per line")
+}
+
+// open opens the source specified by the flags, or else by the
+// environment or config file, with any template variables given for
+// migration files with the template directive.
+func (s *srcFlags) open() (*source.Source, error) {
+	if err := applyConfig(s.flags); err != nil {
+		return nil, err
+	}
+	src, err := source.New(s.srcPath)
+	if err != nil {
+		return nil, err
+	}
+	src.Recursive = s.recursive
+	if s.varsFile != "" || s.vars != nil {
+		src.Vars = make(map[string]string)
+		if s.varsFile != "" {
+			if err := readVarsFile(s.varsFile, src.Vars); err != nil {
+				return nil, err
+			}
+		}
+		// Variables given on the command line take precedence.
+		for k, v := range s.vars {
+			src.Vars[k] = v
+		}
+	}
+	return src, nil
+}
+
+// varsFlag is a repeatable flag of key=value template variables.
+type varsFlag map[string]string
+
+func (v *varsFlag) String() string {
+	var pairs []string
+	for k, val := range *v {
+		pairs = append(pairs, k+"="+val)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (v *varsFlag) Set(s string) error {
+	k, val, err := parseVar(s)
+	if err != nil {
+		return err
+	}
+	if *v == nil {
+		*v = make(varsFlag)
+	}
+	(*v)[k] = val
+	return nil
+}
+
+// readVarsFile reads the template variables in the file into vars. Each
+// line is a key=value pair; blank lines, and lines starting with #, are
+// ignored.
+func readVarsFile(path string, vars map[string]string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return errors.Wrap(err, "could not read vars file")
+	}
+	for i, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		k, v, err := parseVar(line)
+		if err != nil {
+			return errors.Wrapf(err, "%s:%d", path, i+1)
+		}
+		vars[k] = v
+	}
+	return nil
+}
+
+// parseVar parses a key=value template variable.
+func parseVar(s string) (key, value string, err error) {
+	i := strings.IndexByte(s, '=')
+	if i <= 0 {
+		return "", "", errors.Errorf("invalid variable %q: want key=value", s)
+	}
+	return strings.TrimSpace(s[:i]), strings.TrimSpace(s[i+1:]), nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSrcFlagsVars(t *testing.T) {
+	dir, err := ioutil.TempDir("", "migrate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	varsFile := filepath.Join(dir, "vars")
+	err = ioutil.WriteFile(varsFile, []byte("# roles\nreader = app_reader\n\nwriter=app_writer\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &srcFlags{srcPath: dir, varsFile: varsFile}
+	if err := s.vars.Set("writer=override"); err != nil {
+		t.Fatal(err)
+	}
+	src, err := s.open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"reader": "app_reader", "writer": "override"}
+	if !reflect.DeepEqual(src.Vars, want) {
+		t.Errorf("got %v, want %v", src.Vars, want)
+	}
+
+	// Without any variables, Vars is nil.
+	src, err = (&srcFlags{srcPath: dir}).open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if src.Vars != nil {
+		t.Errorf("got %v, want nil", src.Vars)
+	}
+
+	if err := s.vars.Set("missing-equals"); err == nil {
+		t.Error("want error for invalid variable, got nil")
+	}
+}
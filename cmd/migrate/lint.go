@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/google/subcommands"
+)
+
+type Lint struct {
+	srcFlags
+}
+
+func (*Lint) Name() string     { return "lint" }
+func (*Lint) Synopsis() string { return "check migration files for problems" }
+func (*Lint) Usage() string {
+	return `migrate lint -src <migrations folder>:
+    Check migration files for problems: file names that don't match
+    <version>_<name>.sql or R__<name>.sql, versions that aren't
+    timestamps, duplicate versions or names, empty files, files without
+    statements, unterminated strings or comments, invalid directives, and
+    templates that can't be rendered with the given variables.
+
+    Comments that look like directives, but aren't, e.g. "-- migrate: adds
+    users table", are reported as warnings.
+
+    Exits with status 1 if any problems other than warnings are found.
+` + srcConfigUsage
+}
+
+func (cmd *Lint) SetFlags(f *flag.FlagSet) {
+	cmd.srcFlags.register(f)
+}
+
+func (cmd *Lint) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	src, err := cmd.open()
+	must(err)
+	problems, err := src.Validate()
+	must(err)
+	failed := false
+	for _, p := range problems {
+		fmt.Println(p)
+		failed = failed || !p.Warning
+	}
+	if failed {
+		return subcommands.ExitFailure
+	}
+	return subcommands.ExitSuccess
+}
@@ -5,9 +5,13 @@ import (
 	"flag"
 	"log"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 
 	"github.com/google/subcommands"
+
+	_ "github.com/johngibb/migrate/db/sqlite" // for sqlite: connection strings
 )
 
 func main() {
@@ -15,16 +19,35 @@ func main() {
 	subcommands.Register(&Status{}, "")
 	subcommands.Register(&Up{}, "")
 	subcommands.Register(&Create{}, "")
+	subcommands.Register(&Resolve{}, "")
+	subcommands.Register(&Lint{}, "")
+	subcommands.Register(&Baseline{}, "")
+	subcommands.Register(&MarkApplied{}, "")
+	subcommands.Register(&Unmark{}, "")
 	subcommands.Register(subcommands.HelpCommand(), "")
 
 	os.Args = translateLegacyArgs(os.Args)
 
 	flag.Parse()
 	os.Exit(int(
-		subcommands.Execute(context.Background()),
+		subcommands.Execute(interruptible()),
 	))
 }
 
+// interruptible returns a context that is cancelled on SIGINT or SIGTERM,
+// so that commands can stop gracefully, e.g. so that up can record the
+// migration it was running, and release the lock. A second signal exits
+// immediately.
+func interruptible() context.Context {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop() // restore the default behavior for the next signal
+		log.Println("migrate: interrupted, stopping; interrupt again to exit immediately")
+	}()
+	return ctx
+}
+
 // must calls log.Fatal if the error is non-nil.
 func must(err error) {
 	if err != nil {
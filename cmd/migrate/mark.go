@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/google/subcommands"
+
+	"github.com/johngibb/migrate"
+)
+
+type MarkApplied struct {
+	dbFlags
+	srcFlags
+	reason string
+}
+
+func (*MarkApplied) Name() string     { return "mark-applied" }
+func (*MarkApplied) Synopsis() string { return "mark a migration as applied, without running it" }
+func (*MarkApplied) Usage() string {
+	return `migrate mark-applied -src <migrations folder> -conn <connection string> -reason <reason> <migration name>:
+    Mark a migration as applied, without executing it, e.g. after applying
+    it by hand as a hotfix. Any record of it having failed is replaced.
+
+    The change is recorded in the audit table, <table>_audit, along with
+    who made it and the -reason given, which is required.
+` + configUsage
+}
+
+func (cmd *MarkApplied) SetFlags(f *flag.FlagSet) {
+	cmd.dbFlags.register(f)
+	cmd.srcFlags.register(f)
+	f.StringVar(&cmd.reason, "reason", "", "why the migration is being marked, for the audit table")
+}
+
+func (cmd *MarkApplied) Execute(ctx context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	name, ok := parseMarkArgs(f, cmd.reason)
+	if !ok {
+		return subcommands.ExitUsageError
+	}
+	src, err := cmd.open()
+	must(err)
+	db, err := cmd.connect(ctx)
+	must(err)
+	defer db.Close(ctx)
+	must(migrate.MarkApplied(ctx, src, db, name, cmd.reason))
+	return subcommands.ExitSuccess
+}
+
+type Unmark struct {
+	dbFlags
+	srcFlags
+	reason string
+}
+
+func (*Unmark) Name() string     { return "unmark" }
+func (*Unmark) Synopsis() string { return "mark a migration as pending, without reverting it" }
+func (*Unmark) Usage() string {
+	return `migrate unmark -src <migrations folder> -conn <connection string> -reason <reason> <migration name>:
+    Delete the record of a migration having been applied, or having
+    failed, without reverting it, e.g. after reverting it by hand. It is
+    run again by the next up.
+
+    The change is recorded in the audit table, <table>_audit, along with
+    who made it and the -reason given, which is required.
+` + configUsage
+}
+
+func (cmd *Unmark) SetFlags(f *flag.FlagSet) {
+	cmd.dbFlags.register(f)
+	cmd.srcFlags.register(f)
+	f.StringVar(&cmd.reason, "reason", "", "why the migration is being unmarked, for the audit table")
+}
+
+func (cmd *Unmark) Execute(ctx context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	name, ok := parseMarkArgs(f, cmd.reason)
+	if !ok {
+		return subcommands.ExitUsageError
+	}
+	src, err := cmd.open()
+	must(err)
+	db, err := cmd.connect(ctx)
+	must(err)
+	defer db.Close(ctx)
+	must(migrate.Unmark(ctx, src, db, name, cmd.reason))
+	return subcommands.ExitSuccess
+}
+
+// parseMarkArgs returns the migration name passed to mark-applied or
+// unmark, printing usage if it, or the reason, is missing.
+func parseMarkArgs(f *flag.FlagSet, reason string) (string, bool) {
+	if len(f.Args()) < 1 {
+		fmt.Fprint(os.Stderr, "error: missing migration name\n")
+		f.Usage()
+		return "", false
+	}
+	if reason == "" {
+		fmt.Fprint(os.Stderr, "error: -reason is required\n")
+		f.Usage()
+		return "", false
+	}
+	return f.Arg(0), true
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/google/subcommands"
+
+	"github.com/johngibb/migrate"
+)
+
+type Resolve struct {
+	dbFlags
+	srcFlags
+	applied bool
+	clear   bool
+}
+
+func (*Resolve) Name() string     { return "resolve" }
+func (*Resolve) Synopsis() string { return "resolve a migration that failed partway through" }
+func (*Resolve) Usage() string {
+	return `migrate resolve -src <migrations folder> -conn <connection string> (-applied | -clear) <migration name>:
+    Resolve a migration that failed partway through, after cleaning up
+    after it by hand.
+
+    With -applied, mark the migration as applied, e.g. after running its
+    remaining statements by hand. With -clear, clear the failure, e.g.
+    after reverting its applied statements, so that it is run again in
+    full by the next up.
+` + configUsage
+}
+
+func (cmd *Resolve) SetFlags(f *flag.FlagSet) {
+	cmd.dbFlags.register(f)
+	cmd.srcFlags.register(f)
+	f.BoolVar(&cmd.applied, "applied", false, "mark the migration as applied")
+	f.BoolVar(&cmd.clear, "clear", false, "clear the failure, so the migration is retried")
+}
+
+func (cmd *Resolve) Execute(ctx context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	if len(f.Args()) < 1 {
+		fmt.Fprint(os.Stderr, "error: missing migration name\n")
+		f.Usage()
+		return subcommands.ExitUsageError
+	}
+	if cmd.applied == cmd.clear {
+		fmt.Fprint(os.Stderr, "error: exactly one of -applied or -clear is required\n")
+		f.Usage()
+		return subcommands.ExitUsageError
+	}
+	resolution := migrate.ResolveApplied
+	if cmd.clear {
+		resolution = migrate.ResolveClear
+	}
+
+	src, err := cmd.open()
+	must(err)
+	db, err := cmd.connect(ctx)
+	must(err)
+	defer db.Close(ctx)
+	must(migrate.Resolve(ctx, src, db, f.Arg(0), resolution))
+	return subcommands.ExitSuccess
+}
@@ -2,39 +2,72 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"flag"
+	"fmt"
+	"os"
 
 	"github.com/google/subcommands"
 
 	"github.com/johngibb/migrate"
-	"github.com/johngibb/migrate/db"
-	"github.com/johngibb/migrate/source"
 )
 
+// exitPending is the exit status of status -exit-code when migrations are
+// pending or failed, distinct from the status of any other error.
+const exitPending subcommands.ExitStatus = 3
+
 type Status struct {
-	conn    string
-	srcPath string
+	dbFlags
+	srcFlags
+	format   string
+	exitCode bool
 }
 
 func (*Status) Name() string     { return "status" }
 func (*Status) Synopsis() string { return "display the current status of the migrations" }
 func (*Status) Usage() string {
-	return `migrate status:
+	return `migrate status [-format text|json] [-exit-code]:
     Display a list of pending and applied migrations.
-`
+
+    With -format json, print a JSON array with an object per migration.
+    With -exit-code, exit with status 3 if any migrations are pending or
+    failed. Any other error exits with status 1.
+` + configUsage
 }
 
 func (cmd *Status) SetFlags(f *flag.FlagSet) {
-	f.StringVar(&cmd.conn, "conn", "", "postgres connection string")
-	f.StringVar(&cmd.srcPath, "src", ".", "directory containing migration files")
+	cmd.dbFlags.register(f)
+	cmd.srcFlags.register(f)
+	f.StringVar(&cmd.format, "format", "text", "output format: text or json")
+	f.BoolVar(&cmd.exitCode, "exit-code", false, "exit with status 3 if any migrations are pending")
 }
 
 func (cmd *Status) Execute(ctx context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
-	src, err := source.New(cmd.srcPath)
+	if cmd.format != "text" && cmd.format != "json" {
+		fmt.Fprintf(os.Stderr, "error: unknown format: %s\n", cmd.format)
+		f.Usage()
+		return subcommands.ExitUsageError
+	}
+	src, err := cmd.open()
 	must(err)
-	db, err := db.Connect(ctx, cmd.conn)
+	db, err := cmd.connect(ctx)
 	must(err)
 	defer db.Close(ctx)
-	must(migrate.Status(ctx, src, db))
+
+	entries, err := migrate.GetStatus(ctx, src, db)
+	must(err)
+	if cmd.format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if entries == nil {
+			entries = []*migrate.StatusEntry{}
+		}
+		must(enc.Encode(entries))
+	} else {
+		migrate.PrintStatus(entries)
+	}
+	if cmd.exitCode && migrate.HasPending(entries) {
+		return exitPending
+	}
 	return subcommands.ExitSuccess
 }
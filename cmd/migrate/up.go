@@ -3,40 +3,139 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
 
 	"github.com/google/subcommands"
 
 	"github.com/johngibb/migrate"
-	"github.com/johngibb/migrate/db"
-	"github.com/johngibb/migrate/source"
 )
 
 type Up struct {
-	conn    string
-	srcPath string
-	quiet   bool
+	dbFlags
+	srcFlags
+	quiet         bool
+	allowModified bool
+	lockTimeout   time.Duration
+	stmtTimeout   time.Duration
+	pgLockTimeout time.Duration
+	lockRetries   int
+	retryDelay    time.Duration
+	dryRun        bool
+	to            string
+	format        string
 }
 
 func (*Up) Name() string     { return "up" }
 func (*Up) Synopsis() string { return "apply all pending migrations to the db" }
 func (*Up) Usage() string {
-	return `migrate up -src <migrations folder> -conn <connection string> [-quiet]:
+	return `migrate up -src <migrations folder> -conn <connection string> [options]:
     Apply all pending migrations.
-`
+
+    Refuses to run if a previously applied migration has been edited since
+    it was applied. Pass -allow-modified to run anyway, re-stamping the
+    recorded checksums of the edited migrations.
+
+    Only one process may apply migrations at a time. By default, up fails
+    immediately if another process holds the lock; pass -lock-timeout to
+    wait for it instead.
+
+    With -statement-timeout and -pg-lock-timeout, set Postgres's
+    statement_timeout and lock_timeout before each migration, so that DDL
+    waiting for a table lock can't stall other queries queued behind it.
+    A migration can override them with the directives
+    "-- migrate:statement-timeout <duration>" and
+    "-- migrate:pg-lock-timeout <duration>". With -lock-retries, statements
+    that exceed the lock timeout are retried, with exponential backoff
+    starting at -lock-retry-delay; migrations in a transaction are rolled
+    back and retried in full.
+
+    With -dry-run, print the statements that would be executed, in order,
+    without executing anything.
+
+    With -to, stop after applying the given migration, leaving any that
+    follow it pending.
+
+    With -format json, print progress to stdout as JSON lines, one event
+    per line, instead of as text.
+` + configUsage
 }
 
 func (cmd *Up) SetFlags(f *flag.FlagSet) {
-	f.StringVar(&cmd.conn, "conn", "", "postgres connection string")
-	f.StringVar(&cmd.srcPath, "src", ".", "directory containing migration files")
+	cmd.dbFlags.register(f)
+	cmd.srcFlags.register(f)
 	f.BoolVar(&cmd.quiet, "quiet", false, "only print errors")
+	f.BoolVar(&cmd.allowModified, "allow-modified", false, "run even if applied migrations were modified, and re-stamp their checksums")
+	f.StringVar(&cmd.format, "format", "text", "output format: text or json")
+	f.BoolVar(&cmd.dryRun, "dry-run", false, "print the statements that would run, without executing them")
+	f.DurationVar(&cmd.lockTimeout, "lock-timeout", 0, "how long to wait for another process to release the migration lock (e.g. 30s)")
+	f.DurationVar(&cmd.stmtTimeout, "statement-timeout", 0, "postgres statement_timeout for each migration (e.g. 5m)")
+	f.DurationVar(&cmd.pgLockTimeout, "pg-lock-timeout", 0, "postgres lock_timeout for each migration (e.g. 2s)")
+	f.IntVar(&cmd.lockRetries, "lock-retries", 0, "how many times to retry a statement that exceeds the lock timeout")
+	f.DurationVar(&cmd.retryDelay, "lock-retry-delay", time.Second, "how long to wait before the first lock retry, doubling after each")
+	f.StringVar(&cmd.to, "to", "", "version or name of the last migration to apply")
 }
 
 func (cmd *Up) Execute(ctx context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
-	src, err := source.New(cmd.srcPath)
+	var events migrate.EventSink
+	switch cmd.format {
+	case "text":
+	case "json":
+		if cmd.dryRun {
+			fmt.Fprint(os.Stderr, "error: -dry-run does not support -format json\n")
+			f.Usage()
+			return subcommands.ExitUsageError
+		}
+		events = migrate.NewJSONSink(os.Stdout)
+	default:
+		fmt.Fprintf(os.Stderr, "error: unknown format: %s\n", cmd.format)
+		f.Usage()
+		return subcommands.ExitUsageError
+	}
+	src, err := cmd.open()
 	must(err)
-	db, err := db.Connect(ctx, cmd.conn)
+	db, err := cmd.connect(ctx)
 	must(err)
 	defer db.Close(ctx)
-	must(migrate.Up(ctx, src, db, cmd.quiet))
+	opts := migrate.UpOptions{
+		Quiet:            cmd.quiet,
+		AllowModified:    cmd.allowModified,
+		LockTimeout:      cmd.lockTimeout,
+		StatementTimeout: cmd.stmtTimeout,
+		PGLockTimeout:    cmd.pgLockTimeout,
+		LockRetries:      cmd.lockRetries,
+		LockRetryDelay:   cmd.retryDelay,
+		To:               cmd.to,
+		Events:           events,
+	}
+	if cmd.dryRun {
+		plan, err := migrate.Plan(ctx, src, db, opts)
+		must(err)
+		printPlan(plan)
+		return subcommands.ExitSuccess
+	}
+	must(migrate.UpWithOptions(ctx, src, db, opts))
 	return subcommands.ExitSuccess
 }
+
+// printPlan prints the planned migrations to stdout as a SQL script.
+func printPlan(plan []*migrate.PlannedMigration) {
+	if len(plan) == 0 {
+		log.Println("nothing to do")
+		return
+	}
+	for _, m := range plan {
+		if m.Go {
+			fmt.Printf("-- %s (Go)\n\n", m.Name)
+			continue
+		}
+		fmt.Printf("-- %s\n", m.Name)
+		for _, stmt := range m.Statements {
+			fmt.Println(strings.TrimSpace(stmt))
+		}
+		fmt.Println()
+	}
+}
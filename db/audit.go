@@ -0,0 +1,110 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/pkg/errors"
+)
+
+// AuditEntry records a change an operator made to the migrations table
+// by hand, e.g. marking a migration as applied after running it manually.
+type AuditEntry struct {
+	// Name is the name of the migration.
+	Name string
+
+	// Action is what was done, e.g. "mark-applied" or "unmark".
+	Action string
+
+	// At is when it was done.
+	At time.Time
+
+	// By is the OS user that did it, and Hostname the host it was done
+	// from.
+	By       string
+	Hostname string
+
+	// Reason is why it was done, as given by the operator.
+	Reason string
+}
+
+// auditTableName returns the quoted, schema-qualified name of the audit
+// table, which is named after the migrations table.
+func (c *Client) auditTableName() string {
+	if c.schema == "" {
+		return pgx.Identifier{c.table + "_audit"}.Sanitize()
+	}
+	return pgx.Identifier{c.schema, c.table + "_audit"}.Sanitize()
+}
+
+// ensureAuditTable ensures that the audit table exists. Unlike the
+// migrations table, it is only created once something is audited.
+func (c *Client) ensureAuditTable(ctx context.Context) error {
+	if c.auditEnsured {
+		return nil
+	}
+	if err := c.ensureMigrationsTable(ctx); err != nil {
+		return err
+	}
+	_, err := c.conn.Exec(ctx, `
+        create table if not exists `+c.auditTableName()+` (
+            name text not null,
+            action text not null,
+            performed_at timestamptz not null,
+            performed_by text,
+            hostname text,
+            reason text
+        );
+    `)
+	if err != nil {
+		return errors.Wrap(err, "could not create audit table")
+	}
+	c.auditEnsured = true
+	return nil
+}
+
+// LogAudit records the change in the audit table.
+func (c *Client) LogAudit(ctx context.Context, e *AuditEntry) error {
+	if err := c.ensureAuditTable(ctx); err != nil {
+		return err
+	}
+	at := e.At
+	if at.IsZero() {
+		at = time.Now()
+	}
+	_, err := c.conn.Exec(ctx, `
+        insert into `+c.auditTableName()+` (name, action, performed_at, performed_by, hostname, reason)
+        values ($1, $2, $3, $4, $5, $6);
+    `, e.Name, e.Action, at, e.By, e.Hostname, e.Reason)
+	return err
+}
+
+// GetAuditLog returns every change recorded in the audit table, oldest
+// first.
+func (c *Client) GetAuditLog(ctx context.Context) ([]*AuditEntry, error) {
+	if err := c.ensureAuditTable(ctx); err != nil {
+		return nil, err
+	}
+	rows, err := c.conn.Query(ctx, `
+        select name, action, performed_at, coalesce(performed_by, ''), coalesce(hostname, ''), coalesce(reason, '')
+        from `+c.auditTableName()+`
+        order by performed_at;
+    `)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not query audit log")
+	}
+	defer rows.Close()
+	var result []*AuditEntry
+	for rows.Next() {
+		var e AuditEntry
+		if err := rows.Scan(&e.Name, &e.Action, &e.At, &e.By, &e.Hostname, &e.Reason); err != nil {
+			return nil, errors.Wrap(err, "error scanning audit entry")
+		}
+		result = append(result, &e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "could not query audit log")
+	}
+	return result, nil
+}
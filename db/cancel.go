@@ -0,0 +1,22 @@
+package db
+
+import "context"
+
+// StatementCanceler is implemented by drivers that can cancel a running
+// statement from another goroutine, leaving the connection usable, so
+// that the migration can still be recorded as failed, and the lock
+// released. Cancelling the context passed to Exec, by contrast, may
+// close the connection.
+type StatementCanceler interface {
+	// CancelStatement asks the database to cancel the statement running
+	// on the connection, if any, which then fails.
+	CancelStatement(ctx context.Context) error
+}
+
+var _ StatementCanceler = (*Client)(nil)
+
+// CancelStatement sends a cancel request for the connection's running
+// statement, just like pg_cancel_backend.
+func (c *Client) CancelStatement(ctx context.Context) error {
+	return c.conn.PgConn().CancelRequest(ctx)
+}
@@ -4,26 +4,99 @@ package db
 
 import (
 	"context"
+	"time"
 
 	"github.com/jackc/pgx/v4"
 	"github.com/pkg/errors"
 )
 
-// Migration is a migration that's been applied to the database.
+// Migration is a migration that's been applied to the database, or that
+// failed partway through being applied.
 type Migration struct {
+	// Name is the name of the migration.
 	Name string
+
+	// AppliedAt is the time the migration was applied. It is zero for
+	// migrations recorded before this column existed.
+	AppliedAt time.Time
+
+	// Duration is how long the migration took to execute.
+	Duration time.Duration
+
+	// Checksum is the checksum of the migration source file at the time
+	// it was applied.
+	Checksum string
+
+	// ToolVersion is the version of migrate that applied the migration.
+	ToolVersion string
+
+	// AppliedBy is the OS user that applied the migration.
+	AppliedBy string
+
+	// Hostname is the host the migration was applied from.
+	Hostname string
+
+	// Baselined is set if the migration was recorded as applied by
+	// baselining an existing database, without being executed.
+	Baselined bool
+
+	// Reason is why an operator marked the migration as applied by hand,
+	// if they did.
+	Reason string
+
+	// Failed is set if the migration failed, in which case the
+	// statements before FailedStatement were executed, but the rest
+	// were not.
+	Failed bool
+
+	// FailedStatement is the zero-based index of the statement that
+	// failed.
+	FailedStatement int
+
+	// Error is the error returned by the failed statement.
+	Error string
+}
+
+// DefaultTable is the name of the table migrations are recorded in,
+// unless otherwise specified.
+const DefaultTable = "migrations"
+
+// Options configures where a Client records applied migrations.
+type Options struct {
+	// Schema is the schema containing the migrations table. If empty,
+	// the table is resolved using the connection's search_path.
+	Schema string
+
+	// Table is the name of the migrations table. If empty, DefaultTable
+	// is used.
+	Table string
 }
 
 // Client is a migration database connection.
 type Client struct {
 	conn         *pgx.Conn
 	databaseName string
+	schema       string
+	table        string
+	lockSchema   string // schema resolved for the lock ID
 	locked       bool
 	ensured      bool
+	auditEnsured bool
+
+	// release, if set, returns a connection borrowed by FromPool or
+	// FromDB, instead of closing it.
+	release func() error
 }
 
-// Connect connects to the Postgres database at the given uri.
+// Connect connects to the Postgres database at the given uri, recording
+// migrations in the default table.
 func Connect(ctx context.Context, uri string) (*Client, error) {
+	return ConnectWithOptions(ctx, uri, Options{})
+}
+
+// ConnectWithOptions connects to the Postgres database at the given uri,
+// recording migrations in the table specified by opts.
+func ConnectWithOptions(ctx context.Context, uri string, opts Options) (*Client, error) {
 	cfg, err := pgx.ParseConfig(uri)
 	if err != nil {
 		return nil, errors.Wrapf(err, "could not parse uri: %s", uri)
@@ -32,36 +105,125 @@ func Connect(ctx context.Context, uri string) (*Client, error) {
 	if err != nil {
 		return nil, errors.Wrap(err, "could not connect to database")
 	}
-	c := &Client{
-		conn:         conn,
-		databaseName: cfg.Database,
+	return newClient(conn, opts), nil
+}
+
+// tableName returns the quoted, schema-qualified name of the migrations
+// table.
+func (c *Client) tableName() string {
+	if c.schema == "" {
+		return pgx.Identifier{c.table}.Sanitize()
 	}
-	return c, nil
+	return pgx.Identifier{c.schema, c.table}.Sanitize()
+}
+
+// Conn returns the underlying database connection, e.g. for running Go
+// migrations.
+func (c *Client) Conn() *pgx.Conn {
+	return c.conn
 }
 
-// Close closes the underlying database connection.
+// Close closes the underlying database connection, or, if it was borrowed
+// from a pool, returns it. A borrowed connection still holding the
+// migration lock is unlocked first, or closed if that fails, so the lock
+// isn't handed on to whoever uses it next.
 func (c *Client) Close(ctx context.Context) error {
-	return c.conn.Close(ctx)
+	if c.release == nil {
+		return c.conn.Close(ctx)
+	}
+	if c.locked {
+		if _, err := c.Unlock(ctx); err != nil {
+			c.conn.Close(ctx)
+		}
+	}
+	return c.release()
+}
+
+// migrationsColumns are the columns added to the migrations table after
+// the original name column, along with their types. Tables created by
+// older versions are upgraded in place by adding any that are missing.
+var migrationsColumns = []struct{ name, typ string }{
+	{"applied_at", "timestamptz"},
+	{"duration_ms", "bigint"},
+	{"checksum", "text"},
+	{"tool_version", "text"},
+	{"applied_by", "text"},
+	{"hostname", "text"},
+	{"failed_statement", "int"},
+	{"error", "text"},
+	{"baselined", "boolean"},
+	{"reason", "text"},
 }
 
-// ensureMigrationsTable ensures that the migrations table exists.
+// ensureMigrationsTable ensures that the migrations table exists, and
+// has every column this version expects.
 func (c *Client) ensureMigrationsTable(ctx context.Context) error {
 	if c.ensured { // only need to run the full check once
 		return nil
 	}
+	if c.schema != "" {
+		// Check first, since create schema requires the create privilege
+		// on the database, even if the schema exists.
+		var exists bool
+		err := c.conn.QueryRow(ctx, `select exists(select 1 from pg_namespace where nspname = $1);`, c.schema).Scan(&exists)
+		if err != nil {
+			return errors.Wrap(err, "could not query schema")
+		}
+		if !exists {
+			_, err := c.conn.Exec(ctx, `create schema `+pgx.Identifier{c.schema}.Sanitize()+`;`)
+			if err != nil {
+				return err
+			}
+		}
+	}
 	_, err := c.conn.Exec(ctx, `
-        create table if not exists migrations (
+        create table if not exists `+c.tableName()+` (
             name text
         );
     `)
-	if err == nil {
-		c.ensured = true
+	if err != nil {
+		return err
 	}
-	return err
+
+	// Only alter the table when columns are actually missing, since
+	// altering it requires an exclusive lock.
+	rows, err := c.conn.Query(ctx, `
+        select column_name
+        from information_schema.columns
+        where table_schema = coalesce(nullif($1, ''), current_schema()) and table_name = $2;
+    `, c.schema, c.table)
+	if err != nil {
+		return errors.Wrap(err, "could not query migrations table columns")
+	}
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return errors.Wrap(err, "error scanning column")
+		}
+		existing[name] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return errors.Wrap(err, "could not query migrations table columns")
+	}
+	for _, col := range migrationsColumns {
+		if existing[col.name] {
+			continue
+		}
+		_, err := c.conn.Exec(ctx, `alter table `+c.tableName()+` add column if not exists `+col.name+` `+col.typ+`;`)
+		if err != nil {
+			return errors.Wrapf(err, "could not add column %s to %s", col.name, c.tableName())
+		}
+	}
+	c.ensured = true
+	return nil
 }
 
-// ApplyMigration executes the given statements against the database,
-// and then records that the migration has been applied.
+// Exec executes the given sql against the database. The sql may contain
+// multiple statements, which are sent together using the simple query
+// protocol.
 func (c *Client) Exec(ctx context.Context, sql string) error {
 	if err := c.ensureMigrationsTable(ctx); err != nil {
 		return err
@@ -70,28 +232,145 @@ func (c *Client) Exec(ctx context.Context, sql string) error {
 	return err
 }
 
-func (c *Client) LogCompletedMigration(ctx context.Context, name string) error {
-	_, err := c.conn.Exec(ctx, `insert into migrations values ($1);`, name)
+// LogCompletedMigration records that the migration has been applied,
+// replacing any previous record of it: either of it having failed, or,
+// for repeatable migrations, of it having been applied before.
+func (c *Client) LogCompletedMigration(ctx context.Context, m *Migration) error {
+	return c.replaceMigration(ctx, `name = $1`, m, nil, nil)
+}
+
+// LogFailedMigration records that the migration failed while executing
+// m.FailedStatement, replacing any earlier record of it failing.
+func (c *Client) LogFailedMigration(ctx context.Context, m *Migration) error {
+	return c.replaceMigration(ctx, `name = $1 and failed_statement is not null`, m, &m.FailedStatement, &m.Error)
+}
+
+// ClearFailedMigration deletes the record of the migration having failed,
+// if any.
+func (c *Client) ClearFailedMigration(ctx context.Context, name string) error {
+	if err := c.ensureMigrationsTable(ctx); err != nil {
+		return err
+	}
+	_, err := c.conn.Exec(ctx, `delete from `+c.tableName()+` where name = $1 and failed_statement is not null;`, name)
+	return err
+}
+
+// replaceMigration inserts a record of the migration, deleting the
+// records matching the replace condition, e.g. of it having failed, in
+// the same statement, so that an error or interrupt can't leave it with
+// neither, even outside a transaction.
+func (c *Client) replaceMigration(ctx context.Context, replace string, m *Migration, failedStatement *int, failure *string) error {
+	if err := c.ensureMigrationsTable(ctx); err != nil {
+		return err
+	}
+	appliedAt := m.AppliedAt
+	if appliedAt.IsZero() {
+		appliedAt = time.Now()
+	}
+	_, err := c.conn.Exec(ctx, `
+        with replaced as (
+            delete from `+c.tableName()+` where `+replace+`
+        )
+        insert into `+c.tableName()+` (
+            name, applied_at, duration_ms, checksum, tool_version, applied_by, hostname,
+            failed_statement, error, baselined, reason
+        ) values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11);
+    `,
+		m.Name,
+		appliedAt,
+		m.Duration.Milliseconds(),
+		m.Checksum,
+		m.ToolVersion,
+		m.AppliedBy,
+		m.Hostname,
+		failedStatement,
+		failure,
+		m.Baselined,
+		m.Reason,
+	)
+	return err
+}
+
+// DeleteMigration deletes every record of the migration, so that it is
+// considered pending.
+func (c *Client) DeleteMigration(ctx context.Context, name string) error {
+	if err := c.ensureMigrationsTable(ctx); err != nil {
+		return err
+	}
+	_, err := c.conn.Exec(ctx, `delete from `+c.tableName()+` where name = $1;`, name)
+	return err
+}
+
+// UpdateChecksum replaces the recorded checksum of an applied migration.
+func (c *Client) UpdateChecksum(ctx context.Context, name, checksum string) error {
+	if err := c.ensureMigrationsTable(ctx); err != nil {
+		return err
+	}
+	_, err := c.conn.Exec(ctx, `update `+c.tableName()+` set checksum = $2 where name = $1 and failed_statement is null;`, name, checksum)
 	return err
 }
 
 // GetMigrations returns all migrations that have been applied to the
-// database.
+// database, including those that failed.
 func (c *Client) GetMigrations(ctx context.Context) ([]*Migration, error) {
 	if err := c.ensureMigrationsTable(ctx); err != nil {
 		return nil, err
 	}
-	rows, err := c.conn.Query(ctx, `select name from migrations;`)
+	rows, err := c.conn.Query(ctx, `
+        select
+            name,
+            applied_at,
+            coalesce(duration_ms, 0),
+            coalesce(checksum, ''),
+            coalesce(tool_version, ''),
+            coalesce(applied_by, ''),
+            coalesce(hostname, ''),
+            failed_statement,
+            coalesce(error, ''),
+            coalesce(baselined, false),
+            coalesce(reason, '')
+        from `+c.tableName()+`;
+    `)
 	if err != nil {
 		return nil, errors.Wrap(err, "could not query migrations")
 	}
+	defer rows.Close()
 	var result []*Migration
 	for rows.Next() {
-		var m Migration
-		if err := rows.Scan(&m.Name); err != nil {
+		var (
+			m               Migration
+			appliedAt       *time.Time
+			durationMS      int64
+			failedStatement *int
+		)
+		err := rows.Scan(
+			&m.Name,
+			&appliedAt,
+			&durationMS,
+			&m.Checksum,
+			&m.ToolVersion,
+			&m.AppliedBy,
+			&m.Hostname,
+			&failedStatement,
+			&m.Error,
+			&m.Baselined,
+			&m.Reason,
+		)
+		if err != nil {
 			return nil, errors.Wrap(err, "error scanning migration")
 		}
+		if appliedAt != nil {
+			m.AppliedAt = *appliedAt
+		}
+		if failedStatement != nil {
+			m.Failed = true
+			m.FailedStatement = *failedStatement
+		}
+		m.Duration = time.Duration(durationMS) * time.Millisecond
 		result = append(result, &m)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "could not query migrations")
+	}
 	return result, nil
 }
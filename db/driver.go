@@ -0,0 +1,106 @@
+package db
+
+import (
+	"context"
+	"strings"
+	"sync"
+)
+
+// Driver is a database that migrations can be applied to, and recorded
+// in. Client implements it for Postgres, and other packages, e.g.
+// github.com/johngibb/migrate/db/sqlite, for other databases; Open
+// connects to any of them, depending on the connection URI.
+type Driver interface {
+	// Exec executes the given sql, which may contain multiple
+	// statements. Successive calls must run on the same connection, so
+	// that migrations can begin and commit transactions.
+	Exec(ctx context.Context, sql string) error
+
+	// GetMigrations returns all migrations that have been applied to the
+	// database, including those that failed.
+	GetMigrations(ctx context.Context) ([]*Migration, error)
+
+	// LogCompletedMigration records that the migration has been applied,
+	// replacing any previous record of it.
+	LogCompletedMigration(ctx context.Context, m *Migration) error
+
+	// LogFailedMigration records that the migration failed while
+	// executing m.FailedStatement, replacing any earlier record of it
+	// failing.
+	LogFailedMigration(ctx context.Context, m *Migration) error
+
+	// ClearFailedMigration deletes the record of the migration having
+	// failed, if any.
+	ClearFailedMigration(ctx context.Context, name string) error
+
+	// DeleteMigration deletes every record of the migration, so that it
+	// is considered pending.
+	DeleteMigration(ctx context.Context, name string) error
+
+	// UpdateChecksum re-stamps the checksum recorded for an applied
+	// migration.
+	UpdateChecksum(ctx context.Context, name, checksum string) error
+
+	// LogAudit records a change made to the migrations table by hand.
+	LogAudit(ctx context.Context, e *AuditEntry) error
+
+	// TryLock attempts to acquire an exclusive lock for running
+	// migrations, without waiting.
+	TryLock(ctx context.Context) (bool, error)
+
+	// Unlock releases the lock acquired by TryLock.
+	Unlock(ctx context.Context) (bool, error)
+
+	// Close closes the connection to the database.
+	Close(ctx context.Context) error
+}
+
+// LockInspector is implemented by drivers that can describe who holds
+// the migration lock, which Lock reports while waiting for it.
+type LockInspector interface {
+	// LockHolder returns the session currently holding the migration
+	// lock, or nil if it isn't held.
+	LockHolder(ctx context.Context) (*LockHolder, error)
+}
+
+var (
+	_ Driver        = (*Client)(nil)
+	_ LockInspector = (*Client)(nil)
+)
+
+// OpenFunc opens a Driver for the database at the given uri.
+type OpenFunc func(ctx context.Context, uri string, opts Options) (Driver, error)
+
+var (
+	driversMu sync.Mutex
+	drivers   = make(map[string]OpenFunc)
+)
+
+// Register makes a driver available to Open, for connection URIs with the
+// given scheme, e.g. "sqlite". It is meant to be called from the init
+// function of the driver's package, so that programs only link the
+// drivers they import, and panics if the scheme is already registered.
+func Register(scheme string, open OpenFunc) {
+	driversMu.Lock()
+	defer driversMu.Unlock()
+	if _, ok := drivers[scheme]; ok {
+		panic("db: Register called twice for scheme " + scheme)
+	}
+	drivers[scheme] = open
+}
+
+// Open connects to the database at the given uri, choosing the driver by
+// its scheme: URIs whose scheme was passed to Register, e.g. sqlite://dev.db
+// once github.com/johngibb/migrate/db/sqlite is imported, are opened by
+// that driver, and anything else is passed to Postgres.
+func Open(ctx context.Context, uri string, opts Options) (Driver, error) {
+	if i := strings.Index(uri, ":"); i > 0 {
+		driversMu.Lock()
+		open := drivers[uri[:i]]
+		driversMu.Unlock()
+		if open != nil {
+			return open(ctx, uri, opts)
+		}
+	}
+	return ConnectWithOptions(ctx, uri, opts)
+}
@@ -2,22 +2,64 @@ package db
 
 import (
 	"context"
+	"fmt"
 	"hash/fnv"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/pkg/errors"
 )
 
-func generateAdvisoryLockID(database string) int {
+// lockPollInterval is how often Lock retries acquiring the lock while
+// waiting for another process to release it.
+const lockPollInterval = 500 * time.Millisecond
+
+// defaultSchema is the schema an unqualified migrations table is created
+// in, unless the search_path says otherwise.
+const defaultSchema = "public"
+
+// generateAdvisoryLockID derives the advisory lock ID from the database
+// and migrations table, so that each migrations table is locked
+// independently. The default table in the default schema yields the same
+// ID as older versions.
+func generateAdvisoryLockID(database, schema, table string) int {
+	if schema == defaultSchema {
+		schema = ""
+	}
 	h := fnv.New32a()
 	h.Write([]byte(database))
+	if schema != "" || table != DefaultTable {
+		h.Write([]byte(schema + "." + table))
+	}
 	h.Write([]byte("migrate"))
 	return int(h.Sum32())
 }
 
+// lockID returns the advisory lock ID for the migrations table. An empty
+// schema is first resolved with current_schema(), so that it shares a lock
+// with naming the same schema explicitly.
+func (c *Client) lockID(ctx context.Context) (int, error) {
+	if c.lockSchema == "" {
+		c.lockSchema = c.schema
+		if c.lockSchema == "" {
+			err := c.conn.QueryRow(ctx, `select coalesce(current_schema(), '');`).Scan(&c.lockSchema)
+			if err != nil {
+				return 0, errors.Wrap(err, "could not resolve schema")
+			}
+		}
+	}
+	return generateAdvisoryLockID(c.databaseName, c.lockSchema, c.table), nil
+}
+
 // TryLock attempts to acquire an exclusive lock for running migrations
 // on this database.
 func (c *Client) TryLock(ctx context.Context) (bool, error) {
-	id := generateAdvisoryLockID(c.databaseName)
+	id, err := c.lockID(ctx)
+	if err != nil {
+		return false, err
+	}
 	var success bool
-	err := c.conn.QueryRow(ctx, `select pg_try_advisory_lock($1);`, id).Scan(&success)
+	err = c.conn.QueryRow(ctx, `select pg_try_advisory_lock($1);`, id).Scan(&success)
 	if err != nil {
 		return false, err
 	}
@@ -27,11 +69,114 @@ func (c *Client) TryLock(ctx context.Context) (bool, error) {
 	return success, nil
 }
 
+// Lock acquires the driver's exclusive lock for running migrations,
+// waiting up to timeout for another process to release it. A zero timeout
+// fails immediately, just like TryLock.
+//
+// While waiting, onWait (if non-nil) is called with the process holding
+// the lock whenever it changes, if the driver is a LockInspector.
+func Lock(ctx context.Context, d Driver, timeout time.Duration, onWait func(*LockHolder)) (bool, error) {
+	deadline := time.Now().Add(timeout)
+	inspector, _ := d.(LockInspector)
+	var lastPID int
+	for {
+		success, err := d.TryLock(ctx)
+		if err != nil || success {
+			return success, err
+		}
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return false, nil
+		}
+		if onWait != nil && inspector != nil {
+			holder, err := inspector.LockHolder(ctx)
+			if err != nil {
+				return false, err
+			}
+			if holder != nil && holder.PID != lastPID {
+				lastPID = holder.PID
+				onWait(holder)
+			}
+		}
+		if remaining > lockPollInterval {
+			remaining = lockPollInterval
+		}
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case <-time.After(remaining):
+		}
+	}
+}
+
+// LockHolder describes the database session, or process, holding the
+// migration lock.
+type LockHolder struct {
+	PID             int
+	User            string
+	ClientAddr      string // the session's client address, if known
+	Hostname        string // the client's host name, if known, e.g. if Postgres has log_hostname on
+	ApplicationName string
+	Since           time.Time // when the session started
+}
+
+func (h *LockHolder) String() string {
+	s := fmt.Sprintf("pid %d (%s", h.PID, h.User)
+	if h.ClientAddr != "" {
+		s += "@" + h.ClientAddr
+	}
+	if h.Hostname != "" {
+		s += " on " + h.Hostname
+	}
+	if h.ApplicationName != "" {
+		s += ", " + h.ApplicationName
+	}
+	return s + fmt.Sprintf(", connected since %s)", h.Since.Format(time.RFC3339))
+}
+
+// LockHolder returns the session currently holding the migration lock,
+// or nil if it isn't held.
+func (c *Client) LockHolder(ctx context.Context) (*LockHolder, error) {
+	id, err := c.lockID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var h LockHolder
+	err = c.conn.QueryRow(ctx, `
+        select
+            a.pid,
+            coalesce(a.usename::text, ''),
+            coalesce(host(a.client_addr), ''),
+            coalesce(a.client_hostname, ''),
+            coalesce(a.application_name, ''),
+            a.backend_start
+        from pg_locks l
+        join pg_stat_activity a on a.pid = l.pid
+        where l.locktype = 'advisory'
+          and l.granted
+          and l.database = (select oid from pg_database where datname = current_database())
+          and l.classid = 0
+          and l.objid = $1::bigint::oid
+          and l.objsubid = 1
+        limit 1;
+    `, id).Scan(&h.PID, &h.User, &h.ClientAddr, &h.Hostname, &h.ApplicationName, &h.Since)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "could not query lock holder")
+	}
+	return &h, nil
+}
+
 // Unlock unlocks the exclusive migration lock.
 func (c *Client) Unlock(ctx context.Context) (bool, error) {
-	id := generateAdvisoryLockID(c.databaseName)
+	id, err := c.lockID(ctx)
+	if err != nil {
+		return false, err
+	}
 	var success bool
-	err := c.conn.QueryRow(ctx, `select pg_advisory_unlock($1);`, id).Scan(&success)
+	err = c.conn.QueryRow(ctx, `select pg_advisory_unlock($1);`, id).Scan(&success)
 	if err != nil {
 		return false, err
 	}
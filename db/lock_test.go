@@ -0,0 +1,36 @@
+package db
+
+import "testing"
+
+func TestGenerateAdvisoryLockID(t *testing.T) {
+	// The default table must keep the ID used by older versions, so
+	// that mixed versions still exclude each other.
+	if got, want := generateAdvisoryLockID("app", "", DefaultTable), 3294943697; got != want {
+		t.Errorf("default lock id: got %d, want %d", got, want)
+	}
+
+	// The default schema, named or resolved from an empty one, must share
+	// a lock.
+	if got, want := generateAdvisoryLockID("app", "public", DefaultTable), generateAdvisoryLockID("app", "", DefaultTable); got != want {
+		t.Errorf("public lock id: got %d, want %d", got, want)
+	}
+	if got, want := generateAdvisoryLockID("app", "public", "schema_migrations"), generateAdvisoryLockID("app", "", "schema_migrations"); got != want {
+		t.Errorf("public schema_migrations lock id: got %d, want %d", got, want)
+	}
+
+	ids := map[int]string{}
+	for _, tt := range []struct{ database, schema, table string }{
+		{"app", "", DefaultTable},
+		{"app", "billing", DefaultTable},
+		{"app", "", "schema_migrations"},
+		{"app", "billing", "schema_migrations"},
+		{"other", "", DefaultTable},
+	} {
+		id := generateAdvisoryLockID(tt.database, tt.schema, tt.table)
+		key := tt.database + "/" + tt.schema + "." + tt.table
+		if prev, ok := ids[id]; ok {
+			t.Errorf("%s and %s share lock id %d", prev, key, id)
+		}
+		ids[id] = key
+	}
+}
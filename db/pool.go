@@ -0,0 +1,63 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/jackc/pgx/v4/stdlib"
+	"github.com/pkg/errors"
+)
+
+// FromPool returns a Client that runs migrations on a connection acquired
+// from the given pool, e.g. one an application already has open. The
+// connection is dedicated to the Client, so that the session-level
+// migration lock, and any transaction a migration begins, can't be lost
+// to another connection. Close returns it to the pool, but leaves the
+// pool open.
+func FromPool(ctx context.Context, pool *pgxpool.Pool, opts Options) (*Client, error) {
+	pc, err := pool.Acquire(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not acquire connection")
+	}
+	c := newClient(pc.Conn(), opts)
+	c.release = func() error {
+		pc.Release()
+		return nil
+	}
+	return c, nil
+}
+
+// FromDB returns a Client that runs migrations on a connection taken from
+// the given database/sql handle, which must have been opened with the pgx
+// driver, github.com/jackc/pgx/v4/stdlib. Like FromPool, the connection
+// is dedicated to the Client until Close returns it to db's pool.
+func FromDB(ctx context.Context, db *sql.DB, opts Options) (*Client, error) {
+	conn, err := stdlib.AcquireConn(db)
+	if err == stdlib.ErrNotPgx {
+		return nil, errors.Errorf("unsupported database/sql driver %T; use github.com/jackc/pgx/v4/stdlib", db.Driver())
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "could not acquire connection")
+	}
+	c := newClient(conn, opts)
+	c.release = func() error {
+		return stdlib.ReleaseConn(db, conn)
+	}
+	return c, nil
+}
+
+// newClient returns a Client for the given connection.
+func newClient(conn *pgx.Conn, opts Options) *Client {
+	c := &Client{
+		conn:         conn,
+		databaseName: conn.Config().Database,
+		schema:       opts.Schema,
+		table:        opts.Table,
+	}
+	if c.table == "" {
+		c.table = DefaultTable
+	}
+	return c
+}
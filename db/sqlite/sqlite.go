@@ -0,0 +1,513 @@
+// Package sqlite implements a migrate driver for SQLite databases, e.g.
+// for local development and tests without a Postgres server. Importing it
+// registers the driver with db.Open for sqlite: connection URIs.
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	_ "modernc.org/sqlite" // registers the "sqlite" database/sql driver
+
+	"github.com/johngibb/migrate/db"
+)
+
+func init() {
+	db.Register("sqlite", func(ctx context.Context, uri string, opts db.Options) (db.Driver, error) {
+		c, err := Open(ctx, uri, opts)
+		if err != nil {
+			return nil, err
+		}
+		return c, nil
+	})
+}
+
+var (
+	_ db.Driver            = (*Client)(nil)
+	_ db.LockInspector     = (*Client)(nil)
+	_ db.StatementCanceler = (*Client)(nil)
+)
+
+// scheme is the scheme of connection URIs that db.Open passes to Open.
+const scheme = "sqlite:"
+
+// busyTimeout is how long SQLite waits for other processes to
+// finish writing before failing with SQLITE_BUSY.
+const busyTimeout = 5 * time.Second
+
+// Client is a db.Driver for a SQLite database.
+//
+// SQLite has no advisory locks, so the migration lock is a row in a table
+// named after the migrations table, e.g. migrations_lock, recording the
+// pid and host of the process holding it. If that process dies without
+// releasing it, the next process on the same host to try to lock breaks
+// it.
+type Client struct {
+	db      *sql.DB
+	conn    *sql.Conn // dedicated, so transactions span calls to Exec
+	schema  string
+	table   string
+	locked  bool
+	ensured bool
+
+	mu         sync.Mutex
+	cancelExec context.CancelFunc // interrupts the running Exec, if any
+}
+
+// Open opens the SQLite database at the given uri, of the form
+// sqlite://<path> or sqlite:<path>, or sqlite::memory: for an in-memory
+// database. The path may be followed by query parameters understood by
+// modernc.org/sqlite, e.g. ?_pragma=foreign_keys(1). opts.Schema, if set,
+// names an attached database to record migrations in.
+func Open(ctx context.Context, uri string, opts db.Options) (*Client, error) {
+	dsn := strings.TrimPrefix(strings.TrimPrefix(uri, scheme), "//")
+	if dsn == "" {
+		return nil, errors.Errorf("could not parse uri: %s", uri)
+	}
+	sqlDB, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not parse uri: %s", uri)
+	}
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		sqlDB.Close()
+		return nil, errors.Wrap(err, "could not connect to database")
+	}
+	_, err = conn.ExecContext(ctx, `pragma busy_timeout = `+formatInt(busyTimeout.Milliseconds())+`;`)
+	if err != nil {
+		conn.Close()
+		sqlDB.Close()
+		return nil, errors.Wrap(err, "could not connect to database")
+	}
+	s := &Client{
+		db:     sqlDB,
+		conn:   conn,
+		schema: opts.Schema,
+		table:  opts.Table,
+	}
+	if s.table == "" {
+		s.table = db.DefaultTable
+	}
+	return s, nil
+}
+
+// DB returns the underlying database, e.g. for running Go migrations.
+// Note that, unlike Exec, it may run queries on a different connection.
+func (s *Client) DB() *sql.DB {
+	return s.db
+}
+
+// Close closes the database.
+func (s *Client) Close(ctx context.Context) error {
+	if err := s.conn.Close(); err != nil {
+		s.db.Close()
+		return err
+	}
+	return s.db.Close()
+}
+
+// name returns the quoted, schema-qualified name of the table with the
+// given suffix, e.g. "_lock", added to the name of the migrations table.
+func (s *Client) name(suffix string) string {
+	quote := func(id string) string {
+		return `"` + strings.ReplaceAll(id, `"`, `""`) + `"`
+	}
+	if s.schema == "" {
+		return quote(s.table + suffix)
+	}
+	return quote(s.schema) + "." + quote(s.table+suffix)
+}
+
+// ensureMigrationsTable ensures that the migrations, lock, and audit
+// tables exist.
+func (s *Client) ensureMigrationsTable(ctx context.Context) error {
+	if s.ensured {
+		return nil
+	}
+	_, err := s.conn.ExecContext(ctx, `
+        create table if not exists `+s.name("")+` (
+            name text not null,
+            applied_at text,
+            duration_ms integer,
+            checksum text,
+            tool_version text,
+            applied_by text,
+            hostname text,
+            failed_statement integer,
+            error text,
+            baselined boolean,
+            reason text
+        );
+        create table if not exists `+s.name("_lock")+` (
+            id integer primary key check (id = 1),
+            pid integer,
+            user text,
+            hostname text,
+            since text
+        );
+        create table if not exists `+s.name("_audit")+` (
+            name text not null,
+            action text not null,
+            performed_at text not null,
+            performed_by text,
+            hostname text,
+            reason text
+        );
+    `)
+	if err != nil {
+		return errors.Wrap(err, "could not create migrations table")
+	}
+	s.ensured = true
+	return nil
+}
+
+// Exec executes the given sql against the database.
+func (s *Client) Exec(ctx context.Context, sql string) error {
+	if err := s.ensureMigrationsTable(ctx); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	s.mu.Lock()
+	s.cancelExec = cancel
+	s.mu.Unlock()
+	_, err := s.conn.ExecContext(ctx, sql)
+	s.mu.Lock()
+	s.cancelExec = nil
+	s.mu.Unlock()
+	return err
+}
+
+// GetMigrations returns all migrations that have been applied to the
+// database, including those that failed.
+func (s *Client) GetMigrations(ctx context.Context) ([]*db.Migration, error) {
+	if err := s.ensureMigrationsTable(ctx); err != nil {
+		return nil, err
+	}
+	rows, err := s.conn.QueryContext(ctx, `
+        select
+            name,
+            coalesce(applied_at, ''),
+            coalesce(duration_ms, 0),
+            coalesce(checksum, ''),
+            coalesce(tool_version, ''),
+            coalesce(applied_by, ''),
+            coalesce(hostname, ''),
+            failed_statement,
+            coalesce(error, ''),
+            coalesce(baselined, false),
+            coalesce(reason, '')
+        from `+s.name("")+`;
+    `)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not query migrations")
+	}
+	defer rows.Close()
+	var result []*db.Migration
+	for rows.Next() {
+		var (
+			m               db.Migration
+			appliedAt       string
+			durationMS      int64
+			failedStatement sql.NullInt64
+		)
+		err := rows.Scan(
+			&m.Name,
+			&appliedAt,
+			&durationMS,
+			&m.Checksum,
+			&m.ToolVersion,
+			&m.AppliedBy,
+			&m.Hostname,
+			&failedStatement,
+			&m.Error,
+			&m.Baselined,
+			&m.Reason,
+		)
+		if err != nil {
+			return nil, errors.Wrap(err, "error scanning migration")
+		}
+		if appliedAt != "" {
+			if m.AppliedAt, err = time.Parse(time.RFC3339Nano, appliedAt); err != nil {
+				return nil, errors.Wrap(err, "error scanning migration")
+			}
+		}
+		if failedStatement.Valid {
+			m.Failed = true
+			m.FailedStatement = int(failedStatement.Int64)
+		}
+		m.Duration = time.Duration(durationMS) * time.Millisecond
+		result = append(result, &m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "could not query migrations")
+	}
+	return result, nil
+}
+
+// LogCompletedMigration records that the migration has been applied,
+// replacing any previous record of it.
+func (s *Client) LogCompletedMigration(ctx context.Context, m *db.Migration) error {
+	return s.replaceMigration(ctx, `name = ?`, m, nil, nil)
+}
+
+// LogFailedMigration records that the migration failed while executing
+// m.FailedStatement, replacing any earlier record of it failing.
+func (s *Client) LogFailedMigration(ctx context.Context, m *db.Migration) error {
+	return s.replaceMigration(ctx, `name = ? and failed_statement is not null`, m, &m.FailedStatement, &m.Error)
+}
+
+// ClearFailedMigration deletes the record of the migration having failed,
+// if any.
+func (s *Client) ClearFailedMigration(ctx context.Context, name string) error {
+	if err := s.ensureMigrationsTable(ctx); err != nil {
+		return err
+	}
+	_, err := s.conn.ExecContext(ctx, `delete from `+s.name("")+` where name = ? and failed_statement is not null;`, name)
+	return err
+}
+
+// DeleteMigration deletes every record of the migration, so that it is
+// considered pending.
+func (s *Client) DeleteMigration(ctx context.Context, name string) error {
+	if err := s.ensureMigrationsTable(ctx); err != nil {
+		return err
+	}
+	_, err := s.conn.ExecContext(ctx, `delete from `+s.name("")+` where name = ?;`, name)
+	return err
+}
+
+// replaceMigration inserts a record of the migration, deleting the
+// records matching the replace condition, e.g. of it having failed, within
+// a savepoint, so that an error or interrupt can't leave it with neither.
+// Unlike begin, a savepoint works whether or not the migration has opened
+// a transaction.
+func (s *Client) replaceMigration(ctx context.Context, replace string, m *db.Migration, failedStatement *int, failure *string) (err error) {
+	if err := s.ensureMigrationsTable(ctx); err != nil {
+		return err
+	}
+	appliedAt := m.AppliedAt
+	if appliedAt.IsZero() {
+		appliedAt = time.Now()
+	}
+	if _, err := s.conn.ExecContext(ctx, `savepoint replace_migration;`); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			s.conn.ExecContext(ctx, `rollback to replace_migration;`)
+		}
+		if _, e := s.conn.ExecContext(ctx, `release replace_migration;`); err == nil {
+			err = e
+		}
+	}()
+	if _, err := s.conn.ExecContext(ctx, `delete from `+s.name("")+` where `+replace+`;`, m.Name); err != nil {
+		return err
+	}
+	_, err = s.conn.ExecContext(ctx, `
+        insert into `+s.name("")+` (
+            name, applied_at, duration_ms, checksum, tool_version, applied_by, hostname,
+            failed_statement, error, baselined, reason
+        ) values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);
+    `,
+		m.Name,
+		appliedAt.UTC().Format(time.RFC3339Nano),
+		m.Duration.Milliseconds(),
+		m.Checksum,
+		m.ToolVersion,
+		m.AppliedBy,
+		m.Hostname,
+		failedStatement,
+		failure,
+		m.Baselined,
+		m.Reason,
+	)
+	return err
+}
+
+// UpdateChecksum re-stamps the checksum recorded for an applied
+// migration.
+func (s *Client) UpdateChecksum(ctx context.Context, name, checksum string) error {
+	if err := s.ensureMigrationsTable(ctx); err != nil {
+		return err
+	}
+	_, err := s.conn.ExecContext(ctx, `update `+s.name("")+` set checksum = ? where name = ? and failed_statement is null;`, checksum, name)
+	return err
+}
+
+// LogAudit records the change in the audit table.
+func (s *Client) LogAudit(ctx context.Context, e *db.AuditEntry) error {
+	if err := s.ensureMigrationsTable(ctx); err != nil {
+		return err
+	}
+	at := e.At
+	if at.IsZero() {
+		at = time.Now()
+	}
+	_, err := s.conn.ExecContext(ctx, `
+        insert into `+s.name("_audit")+` (name, action, performed_at, performed_by, hostname, reason)
+        values (?, ?, ?, ?, ?, ?);
+    `, e.Name, e.Action, at.UTC().Format(time.RFC3339Nano), e.By, e.Hostname, e.Reason)
+	return err
+}
+
+// GetAuditLog returns every change recorded in the audit table, oldest
+// first.
+func (s *Client) GetAuditLog(ctx context.Context) ([]*db.AuditEntry, error) {
+	if err := s.ensureMigrationsTable(ctx); err != nil {
+		return nil, err
+	}
+	rows, err := s.conn.QueryContext(ctx, `
+        select name, action, performed_at, coalesce(performed_by, ''), coalesce(hostname, ''), coalesce(reason, '')
+        from `+s.name("_audit")+`
+        order by performed_at;
+    `)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not query audit log")
+	}
+	defer rows.Close()
+	var result []*db.AuditEntry
+	for rows.Next() {
+		var (
+			e  db.AuditEntry
+			at string
+		)
+		if err := rows.Scan(&e.Name, &e.Action, &at, &e.By, &e.Hostname, &e.Reason); err != nil {
+			return nil, errors.Wrap(err, "error scanning audit entry")
+		}
+		if e.At, err = time.Parse(time.RFC3339Nano, at); err != nil {
+			return nil, errors.Wrap(err, "error scanning audit entry")
+		}
+		result = append(result, &e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "could not query audit log")
+	}
+	return result, nil
+}
+
+// TryLock attempts to acquire an exclusive lock for running migrations
+// on this database, by inserting the only row of the lock table. A lock
+// left behind by a process on this host that's no longer running, e.g.
+// one that was killed, is broken first.
+func (s *Client) TryLock(ctx context.Context) (bool, error) {
+	if err := s.ensureMigrationsTable(ctx); err != nil {
+		return false, err
+	}
+	if err := s.breakStaleLock(ctx); err != nil {
+		return false, err
+	}
+	var username string
+	if u, err := user.Current(); err == nil {
+		username = u.Username
+	}
+	host, _ := os.Hostname()
+	res, err := s.conn.ExecContext(ctx, `
+        insert or ignore into `+s.name("_lock")+` (id, pid, user, hostname, since)
+        values (1, ?, ?, ?, ?);
+    `, os.Getpid(), username, host, time.Now().UTC().Format(time.RFC3339Nano))
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if n == 1 {
+		s.locked = true
+	}
+	return n == 1, nil
+}
+
+// breakStaleLock deletes the lock table's row if it was left behind by a
+// process on this host that's no longer running. Locks held by processes
+// on other hosts, e.g. over a network file system, can't be checked, so
+// are left alone.
+func (s *Client) breakStaleLock(ctx context.Context) error {
+	h, err := s.LockHolder(ctx)
+	if err != nil || h == nil {
+		return err
+	}
+	host, _ := os.Hostname()
+	if h.Hostname != host || processExists(h.PID) {
+		return nil
+	}
+	_, err = s.conn.ExecContext(ctx, `delete from `+s.name("_lock")+` where id = 1 and pid = ? and hostname = ?;`, h.PID, h.Hostname)
+	return errors.Wrap(err, "could not break stale lock")
+}
+
+// processExists reports whether the process with the given pid on this
+// host may still be running. Only a definite "no such process" counts as
+// not running, so a pid that can't be checked keeps its lock.
+func processExists(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	return !errors.Is(p.Signal(syscall.Signal(0)), os.ErrProcessDone)
+}
+
+// LockHolder returns the process currently holding the migration lock,
+// or nil if it isn't held.
+func (s *Client) LockHolder(ctx context.Context) (*db.LockHolder, error) {
+	if err := s.ensureMigrationsTable(ctx); err != nil {
+		return nil, err
+	}
+	var (
+		h     db.LockHolder
+		since string
+	)
+	err := s.conn.QueryRowContext(ctx, `
+        select pid, coalesce(user, ''), coalesce(hostname, ''), since
+        from `+s.name("_lock")+`
+        where id = 1;
+    `).Scan(&h.PID, &h.User, &h.Hostname, &since)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "could not query lock holder")
+	}
+	if h.Since, err = time.Parse(time.RFC3339Nano, since); err != nil {
+		return nil, errors.Wrap(err, "could not query lock holder")
+	}
+	return &h, nil
+}
+
+// Unlock releases the migration lock, if this process holds it.
+func (s *Client) Unlock(ctx context.Context) (bool, error) {
+	if !s.locked {
+		return false, nil
+	}
+	if _, err := s.conn.ExecContext(ctx, `delete from `+s.name("_lock")+` where id = 1;`); err != nil {
+		return false, err
+	}
+	s.locked = false
+	return true, nil
+}
+
+// formatInt formats n for inclusion in a statement, e.g. a pragma, that
+// can't take parameters.
+func formatInt(n int64) string {
+	return strconv.FormatInt(n, 10)
+}
+
+// CancelStatement interrupts the statement running in Exec, if any. The
+// queries that record migrations, and take the lock, don't run in Exec, so
+// are never interrupted.
+func (s *Client) CancelStatement(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cancelExec != nil {
+		s.cancelExec()
+	}
+	return nil
+}
@@ -0,0 +1,273 @@
+package sqlite
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/johngibb/migrate/db"
+)
+
+func open(ctx context.Context, t *testing.T, uri string) *Client {
+	t.Helper()
+	s, err := Open(ctx, uri, db.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close(ctx) })
+	return s
+}
+
+func TestMigrations(t *testing.T) {
+	ctx := context.Background()
+	s := open(ctx, t, "sqlite::memory:")
+
+	appliedAt := time.Date(2020, 1, 2, 15, 4, 5, 6, time.UTC)
+	err := s.LogFailedMigration(ctx, &db.Migration{
+		Name:            "1_first",
+		AppliedAt:       appliedAt,
+		FailedStatement: 2,
+		Error:           "syntax error",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.GetMigrations(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || !got[0].Failed || got[0].FailedStatement != 2 || got[0].Error != "syntax error" {
+		t.Fatalf("got %+v, want one failed migration", got)
+	}
+	if !got[0].AppliedAt.Equal(appliedAt) {
+		t.Errorf("applied at: got %v, want %v", got[0].AppliedAt, appliedAt)
+	}
+
+	err = s.LogCompletedMigration(ctx, &db.Migration{
+		Name:      "1_first",
+		AppliedAt: appliedAt,
+		Duration:  1500 * time.Millisecond,
+		Checksum:  "abc",
+		Reason:    "hotfix",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.UpdateChecksum(ctx, "1_first", "def"); err != nil {
+		t.Fatal(err)
+	}
+	got, err = s.GetMigrations(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := db.Migration{
+		Name:      "1_first",
+		AppliedAt: appliedAt,
+		Duration:  1500 * time.Millisecond,
+		Checksum:  "def",
+		Reason:    "hotfix",
+	}
+	if len(got) != 1 || *got[0] != want {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+
+	if err := s.DeleteMigration(ctx, "1_first"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err = s.GetMigrations(ctx); err != nil || len(got) != 0 {
+		t.Fatalf("got %v, %v; want no migrations", got, err)
+	}
+}
+
+func TestReplaceMigrationAtomic(t *testing.T) {
+	ctx := context.Background()
+	s := open(ctx, t, "sqlite::memory:")
+	failed := &db.Migration{Name: "1_first", FailedStatement: 1, Error: "syntax error"}
+	if err := s.LogFailedMigration(ctx, failed); err != nil {
+		t.Fatal(err)
+	}
+
+	// Make recording the migration fail after its old record is deleted,
+	// and confirm the old record is kept, in or out of a transaction.
+	err := s.Exec(ctx, `
+        create trigger fail before insert on migrations
+        begin select raise(abort, 'disk full'); end;
+    `)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, inTx := range []bool{false, true} {
+		if inTx {
+			if err := s.Exec(ctx, `begin;`); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := s.LogCompletedMigration(ctx, &db.Migration{Name: "1_first"}); err == nil {
+			t.Fatalf("in transaction %v: want error, got nil", inTx)
+		}
+		if err := s.LogFailedMigration(ctx, failed); err == nil {
+			t.Fatalf("in transaction %v: want error, got nil", inTx)
+		}
+		if inTx {
+			if err := s.Exec(ctx, `commit;`); err != nil {
+				t.Fatal(err)
+			}
+		}
+		got, err := s.GetMigrations(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 1 || !got[0].Failed || got[0].Error != "syntax error" {
+			t.Fatalf("in transaction %v: got %+v, want the failed record", inTx, got)
+		}
+	}
+}
+
+func TestOpen(t *testing.T) {
+	ctx := context.Background()
+	d, err := db.Open(ctx, "sqlite::memory:", db.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close(ctx)
+	if _, ok := d.(*Client); !ok {
+		t.Errorf("got %T, want *Client", d)
+	}
+}
+
+func TestLock(t *testing.T) {
+	ctx := context.Background()
+	uri := "sqlite://" + filepath.Join(t.TempDir(), "test.db")
+	a := open(ctx, t, uri)
+	b := open(ctx, t, uri)
+
+	if ok, err := a.TryLock(ctx); err != nil || !ok {
+		t.Fatalf("a.TryLock: got %v, %v; want true", ok, err)
+	}
+	if ok, err := b.TryLock(ctx); err != nil || ok {
+		t.Fatalf("b.TryLock: got %v, %v; want false", ok, err)
+	}
+	h, err := b.LockHolder(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h == nil || h.Since.IsZero() {
+		t.Fatalf("got holder %+v, want a", h)
+	}
+	if ok, err := b.Unlock(ctx); err != nil || ok {
+		t.Fatalf("b.Unlock: got %v, %v; want false", ok, err)
+	}
+	if ok, err := a.Unlock(ctx); err != nil || !ok {
+		t.Fatalf("a.Unlock: got %v, %v; want true", ok, err)
+	}
+	if ok, err := b.TryLock(ctx); err != nil || !ok {
+		t.Fatalf("b.TryLock after unlock: got %v, %v; want true", ok, err)
+	}
+	if h, err := a.LockHolder(ctx); err != nil || h == nil {
+		t.Fatalf("got holder %v, %v; want b", h, err)
+	}
+}
+
+func TestStaleLock(t *testing.T) {
+	ctx := context.Background()
+	s := open(ctx, t, "sqlite://"+filepath.Join(t.TempDir(), "test.db"))
+
+	// Find the pid of a process that's no longer running.
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	dead := cmd.Process.Pid
+	host, _ := os.Hostname()
+	lock := func(pid int, hostname string) {
+		t.Helper()
+		_, err := s.DB().ExecContext(ctx, `
+            insert or replace into migrations_lock (id, pid, user, hostname, since)
+            values (1, ?, 'alice', ?, '2020-01-02T15:04:05Z');
+        `, pid, hostname)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.GetMigrations(ctx); err != nil { // create the tables
+		t.Fatal(err)
+	}
+
+	// A lock held by a process on another host can't be checked, so it's
+	// left alone.
+	lock(dead, host+".elsewhere")
+	if ok, err := s.TryLock(ctx); err != nil || ok {
+		t.Fatalf("TryLock with lock held elsewhere: got %v, %v; want false", ok, err)
+	}
+	h, err := s.LockHolder(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (db.LockHolder{PID: dead, User: "alice", Hostname: host + ".elsewhere", Since: time.Date(2020, 1, 2, 15, 4, 5, 0, time.UTC)}); h == nil || *h != want {
+		t.Fatalf("got holder %+v, want %+v", h, want)
+	}
+
+	// A lock left behind by a dead process on this host is broken.
+	lock(dead, host)
+	if ok, err := s.TryLock(ctx); err != nil || !ok {
+		t.Fatalf("TryLock with stale lock: got %v, %v; want true", ok, err)
+	}
+	if h, err := s.LockHolder(ctx); err != nil || h == nil || h.PID != os.Getpid() {
+		t.Fatalf("got holder %+v, %v; want this process", h, err)
+	}
+}
+
+func TestAudit(t *testing.T) {
+	ctx := context.Background()
+	s := open(ctx, t, "sqlite::memory:")
+
+	at := time.Date(2020, 1, 2, 15, 4, 5, 0, time.UTC)
+	e := db.AuditEntry{Name: "1_first", Action: "unmark", At: at, By: "alice", Hostname: "ci", Reason: "reverted"}
+	if err := s.LogAudit(ctx, &e); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.GetAuditLog(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || *got[0] != e {
+		t.Fatalf("got %+v, want %+v", got, e)
+	}
+}
+
+func TestCancelStatement(t *testing.T) {
+	ctx := context.Background()
+	s := open(ctx, t, "sqlite::memory:")
+	if err := s.Exec(ctx, `create table t(id int);`); err != nil {
+		t.Fatal(err)
+	}
+
+	// Cancel a statement that would otherwise run for a very long time.
+	done := make(chan error)
+	go func() {
+		done <- s.Exec(ctx, `
+            with recursive n(i) as (select 1 union all select i + 1 from n)
+            insert into t select i from n;
+        `)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	if err := s.CancelStatement(ctx); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("want error, got nil")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("statement was not cancelled")
+	}
+
+	// Confirm the connection is still usable.
+	if err := s.Exec(ctx, `insert into t values (1);`); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,58 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/pkg/errors"
+)
+
+// TimeoutSetter is implemented by drivers that can limit how long
+// statements run, and wait for locks, which Up sets before each
+// migration.
+type TimeoutSetter interface {
+	// SetTimeouts sets the statement and lock timeouts for subsequent
+	// statements. A zero timeout restores the session's default.
+	SetTimeouts(ctx context.Context, statement, lock time.Duration) error
+}
+
+var _ TimeoutSetter = (*Client)(nil)
+
+// lockNotAvailable is the SQLSTATE Postgres reports when a statement is
+// cancelled by lock_timeout.
+const lockNotAvailable = "55P03"
+
+// IsLockTimeout reports whether err is a statement failing because it
+// waited longer than the lock timeout to acquire a lock.
+func IsLockTimeout(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == lockNotAvailable
+}
+
+// SetTimeouts sets the session's statement_timeout and lock_timeout, or
+// resets them if zero.
+func (c *Client) SetTimeouts(ctx context.Context, statement, lock time.Duration) error {
+	for _, t := range []struct {
+		name  string
+		value time.Duration
+	}{
+		{"statement_timeout", statement},
+		{"lock_timeout", lock},
+	} {
+		var err error
+		if t.value > 0 {
+			// Round up, since Postgres takes whole milliseconds, and
+			// treats zero as no timeout at all.
+			ms := (t.value + time.Millisecond - 1) / time.Millisecond
+			_, err = c.conn.Exec(ctx, `select set_config($1, $2, false);`, t.name, fmt.Sprintf("%dms", ms))
+		} else {
+			_, err = c.conn.Exec(ctx, `reset `+t.name+`;`)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "could not set %s", t.name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+package migrate
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+// EventType identifies what happened while applying migrations.
+type EventType string
+
+const (
+	// EventLockWaiting is sent while waiting for another process to
+	// release the migration lock. Message describes the holder.
+	EventLockWaiting EventType = "lock_waiting"
+
+	// EventLockAcquired is sent once the migration lock is acquired.
+	EventLockAcquired EventType = "lock_acquired"
+
+	// EventLockReleased is sent once the migration lock is released.
+	EventLockReleased EventType = "lock_released"
+
+	// EventChecksumUpdated is sent when the recorded checksum of a
+	// modified migration is re-stamped.
+	EventChecksumUpdated EventType = "checksum_updated"
+
+	// EventNothingToDo is sent if there are no pending migrations.
+	EventNothingToDo EventType = "nothing_to_do"
+
+	// EventMigrationStarted is sent before a migration's first statement
+	// is executed.
+	EventMigrationStarted EventType = "migration_started"
+
+	// EventStatementStarted is sent before each statement is executed.
+	EventStatementStarted EventType = "statement_started"
+
+	// EventStatementFinished is sent after each statement is executed,
+	// with its duration, and its error if it failed.
+	EventStatementFinished EventType = "statement_finished"
+
+	// EventMigrationFinished is sent after a migration is applied, or
+	// fails, with its duration, and its error if it failed.
+	EventMigrationFinished EventType = "migration_finished"
+
+	// EventWarning is sent for errors that don't stop the run, such as
+	// failing to record a failed migration. Message describes it.
+	EventWarning EventType = "warning"
+)
+
+// Event describes progress made while applying migrations.
+type Event struct {
+	Type EventType `json:"type"`
+	Time time.Time `json:"time"`
+
+	// Migration is the name of the migration the event concerns.
+	Migration string `json:"migration,omitempty"`
+
+	// Transaction is set on migration events if the migration runs in a
+	// transaction.
+	Transaction bool `json:"transaction,omitempty"`
+
+	// Statement and StatementIndex identify the statement that statement
+	// events concern.
+	Statement      string `json:"statement,omitempty"`
+	StatementIndex int    `json:"-"` // zero-based; encoded for statement events only
+
+	// Duration is how long the statement or migration took to execute.
+	Duration time.Duration `json:"-"` // encoded as duration_ms
+
+	// Error is set if the statement or migration failed.
+	Error string `json:"error,omitempty"`
+
+	// Message is a description of the event, for those that need one.
+	Message string `json:"message,omitempty"`
+}
+
+// MarshalJSON encodes the event, with its duration in milliseconds.
+// Statement indexes and durations are only included in the events they
+// apply to, since zero is a meaningful value for both.
+func (e *Event) MarshalJSON() ([]byte, error) {
+	type event Event // avoid recursing into MarshalJSON
+	var (
+		index      *int
+		durationMS *float64
+	)
+	if e.Type == EventStatementStarted || e.Type == EventStatementFinished {
+		index = &e.StatementIndex
+	}
+	if e.Type == EventStatementFinished || e.Type == EventMigrationFinished {
+		ms := float64(e.Duration) / float64(time.Millisecond)
+		durationMS = &ms
+	}
+	return json.Marshal(struct {
+		*event
+		StatementIndex *int     `json:"statement_index,omitempty"`
+		DurationMS     *float64 `json:"duration_ms,omitempty"`
+	}{(*event)(e), index, durationMS})
+}
+
+// EventSink receives events as migrations are applied.
+type EventSink interface {
+	Event(e *Event)
+}
+
+// TextSink logs events as human-readable text.
+type TextSink struct {
+	Logger *log.Logger
+}
+
+// Event implements EventSink.
+func (s *TextSink) Event(e *Event) {
+	switch e.Type {
+	case EventLockWaiting:
+		s.Logger.Printf("Waiting for lock held by %s", e.Message)
+	case EventChecksumUpdated:
+		s.Logger.Printf("Re-stamped checksum of modified migration %s", e.Migration)
+	case EventNothingToDo:
+		s.Logger.Println("nothing to do")
+	case EventMigrationStarted:
+		if e.Transaction {
+			s.Logger.Printf("Running %s (in transaction):", e.Migration)
+		} else {
+			s.Logger.Printf("Running %s:", e.Migration)
+		}
+	case EventStatementStarted:
+		s.Logger.Println(prefixAll("> ", e.Statement))
+	case EventStatementFinished:
+		if e.Error != "" {
+			s.Logger.Printf("=> FAIL (%s)", e.Duration)
+		} else {
+			s.Logger.Printf("=> OK (%v)", e.Duration)
+		}
+	case EventWarning:
+		s.Logger.Println(e.Message)
+	}
+}
+
+// JSONSink writes each event as a line of JSON.
+type JSONSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewJSONSink returns a JSONSink that writes to w.
+func NewJSONSink(w io.Writer) *JSONSink {
+	return &JSONSink{w: w}
+}
+
+// Event implements EventSink.
+func (s *JSONSink) Event(e *Event) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return // only possible if Event is changed to contain unsupported types
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.w.Write(append(b, '\n'))
+}
+
+// bufferedSink holds events until they are flushed to another sink.
+type bufferedSink struct {
+	events []*Event
+}
+
+func (s *bufferedSink) Event(e *Event) {
+	s.events = append(s.events, e)
+}
+
+// flush sends the buffered events to the sink.
+func (s *bufferedSink) flush(to EventSink) {
+	for _, e := range s.events {
+		to.Event(e)
+	}
+	s.events = nil
+}
+
+// emit stamps the event with the current time, and sends it to the sink.
+func emit(sink EventSink, e *Event) {
+	e.Time = time.Now()
+	sink.Event(e)
+}
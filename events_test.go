@@ -0,0 +1,83 @@
+package migrate
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTextSink(t *testing.T) {
+	var buf bytes.Buffer
+	sink := &TextSink{Logger: log.New(&buf, "", 0)}
+	for _, e := range []*Event{
+		{Type: EventLockAcquired},
+		{Type: EventMigrationStarted, Migration: "1_first"},
+		{Type: EventStatementStarted, Migration: "1_first", Statement: "create table test(\n  id int\n);"},
+		{Type: EventStatementFinished, Migration: "1_first", Duration: time.Millisecond},
+		{Type: EventStatementStarted, Migration: "1_first", Statement: "invalid;", StatementIndex: 1},
+		{Type: EventStatementFinished, Migration: "1_first", Duration: 2 * time.Millisecond, Error: "syntax error"},
+		{Type: EventMigrationFinished, Migration: "1_first", Error: "syntax error"},
+		{Type: EventLockReleased},
+	} {
+		sink.Event(e)
+	}
+	want := `Running 1_first:
+> create table test(
+>   id int
+> );
+=> OK (1ms)
+> invalid;
+=> FAIL (2ms)
+`
+	if got := buf.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestJSONSink(t *testing.T) {
+	var buf bytes.Buffer
+	sink := NewJSONSink(&buf)
+	at := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, e := range []*Event{
+		{Type: EventMigrationStarted, Time: at, Migration: "1_first", Transaction: true},
+		{Type: EventStatementStarted, Time: at, Migration: "1_first", Statement: "select 1;"},
+		{Type: EventStatementFinished, Time: at, Migration: "1_first", Statement: "select 1;", Duration: 1500 * time.Microsecond},
+	} {
+		sink.Event(e)
+	}
+	want := []string{
+		`{"type":"migration_started","time":"2020-01-02T03:04:05Z","migration":"1_first","transaction":true}`,
+		`{"type":"statement_started","time":"2020-01-02T03:04:05Z","migration":"1_first","statement":"select 1;","statement_index":0}`,
+		`{"type":"statement_finished","time":"2020-01-02T03:04:05Z","migration":"1_first","statement":"select 1;","statement_index":0,"duration_ms":1.5}`,
+	}
+	got := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(got) != len(want) {
+		t.Fatalf("got %d lines, want %d:\n%s", len(got), len(want), buf.String())
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("line %d:\ngot  %s\nwant %s", i, got[i], want[i])
+		}
+		if !json.Valid([]byte(got[i])) {
+			t.Errorf("line %d: invalid json", i)
+		}
+	}
+}
+
+func TestBufferedSink(t *testing.T) {
+	var buf bytes.Buffer
+	to := NewJSONSink(&buf)
+	b := &bufferedSink{}
+	b.Event(&Event{Type: EventLockAcquired})
+	b.Event(&Event{Type: EventLockReleased})
+	if buf.Len() != 0 {
+		t.Fatalf("events sent before flush:\n%s", buf.String())
+	}
+	b.flush(to)
+	if n := strings.Count(buf.String(), "\n"); n != 2 {
+		t.Errorf("got %d events after flush, want 2", n)
+	}
+}
@@ -0,0 +1,155 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/johngibb/migrate/db"
+)
+
+// InterruptedError is returned by Up when its context is cancelled, e.g.
+// by a signal, describing how far it got. Up stops the running statement,
+// if the driver supports it, and starts nothing after it, but still
+// records the outcome and releases the lock.
+type InterruptedError struct {
+	// Completed are the migrations that were applied.
+	Completed []string
+
+	// Stopped is the migration that was stopped partway through, and
+	// recorded as failed, if any. A migration in a transaction is rolled
+	// back, and is retried in full by the next run.
+	Stopped string
+
+	// NotStarted are the pending migrations that weren't started.
+	NotStarted []string
+
+	// Err is the context's error.
+	Err error
+}
+
+func (e *InterruptedError) Error() string {
+	list := func(names []string) string {
+		if len(names) == 0 {
+			return "none"
+		}
+		return strings.Join(names, ", ")
+	}
+	s := fmt.Sprintf("interrupted (%v); applied: %s", e.Err, list(e.Completed))
+	if e.Stopped != "" {
+		s += "; stopped partway: " + e.Stopped
+	}
+	return s + "; not started: " + list(e.NotStarted)
+}
+
+// Unwrap returns the context's error, so that errors.Is(err,
+// context.Canceled) holds.
+func (e *InterruptedError) Unwrap() error {
+	return e.Err
+}
+
+// uncancelled carries the values of a context, but not its cancellation
+// or deadline. Up runs its queries with it, so that cancelling the
+// context doesn't close the connection midway, leaving the outcome
+// unrecorded and the lock held.
+type uncancelled struct {
+	context.Context
+}
+
+func (uncancelled) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (uncancelled) Done() <-chan struct{}       { return nil }
+func (uncancelled) Err() error                  { return nil }
+
+// statementRunner executes migration statements, cancelling the running
+// one when ctx is cancelled, if the driver supports it. Nothing else run on
+// the connection is cancelled, e.g. recording that a migration was applied,
+// since a statement that's already run can't be taken back.
+type statementRunner struct {
+	ctx      context.Context
+	client   db.Driver
+	canceler db.StatementCanceler // nil if unsupported
+
+	mu      sync.Mutex
+	running bool // a statement is executing
+	done    chan struct{}
+}
+
+// runStatements returns a statementRunner for the client, which must be
+// stopped once Up is done with it.
+func runStatements(ctx context.Context, client db.Driver) *statementRunner {
+	r := &statementRunner{ctx: ctx, client: client, done: make(chan struct{})}
+	r.canceler, _ = client.(db.StatementCanceler)
+	if r.canceler != nil {
+		go r.cancelOnDone()
+	}
+	return r
+}
+
+// cancelOnDone cancels the running statement, if any, once ctx is
+// cancelled. The lock is held while the cancel request is sent, so that
+// exec can't return, and Up move on to recording the outcome, until it
+// has been.
+func (r *statementRunner) cancelOnDone() {
+	select {
+	case <-r.ctx.Done():
+	case <-r.done:
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.running {
+		// If this fails, the statement just runs to completion, and Up
+		// stops after it instead.
+		r.canceler.CancelStatement(uncancelled{r.ctx})
+	}
+}
+
+// exec executes the migration statement, unless ctx has been cancelled.
+func (r *statementRunner) exec(sql string) error {
+	return r.call(func(ctx context.Context) error {
+		return r.client.Exec(ctx, sql)
+	})
+}
+
+// call calls fn, unless ctx has been cancelled, with a context that isn't
+// cancelled, treating whatever it runs on the connection as the migration
+// statement, which is cancelled instead.
+func (r *statementRunner) call(fn func(ctx context.Context) error) error {
+	r.mu.Lock()
+	if err := r.ctx.Err(); err != nil {
+		r.mu.Unlock()
+		return err
+	}
+	r.running = true
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		r.running = false
+		r.mu.Unlock()
+	}()
+	return fn(uncancelled{r.ctx})
+}
+
+// stop stops watching ctx.
+func (r *statementRunner) stop() {
+	close(r.done)
+}
+
+// interrupted returns the error for ctx being cancelled before pending[i]
+// completed, and after it started, if started is set.
+func interrupted(ctx context.Context, pending []*migration, i int, started bool) error {
+	e := &InterruptedError{Err: ctx.Err()}
+	for _, m := range pending[:i] {
+		e.Completed = append(e.Completed, m.Name)
+	}
+	if started {
+		e.Stopped = pending[i].Name
+		i++
+	}
+	for _, m := range pending[i:] {
+		e.NotStarted = append(e.NotStarted, m.Name)
+	}
+	return e
+}
@@ -0,0 +1,244 @@
+package migrate
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/johngibb/migrate/db"
+	"github.com/johngibb/migrate/db/sqlite"
+	"github.com/johngibb/migrate/source"
+)
+
+func TestUpInterrupted(t *testing.T) {
+	ctx := context.Background()
+	client, err := sqlite.Open(ctx, "sqlite://"+filepath.Join(t.TempDir(), "test.db"), db.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close(ctx)
+	fsys := fstest.MapFS{
+		"1_add_users_table.sql": {Data: []byte(`create table users(id int);`)},
+		"2_add_users.sql": {Data: []byte(`
+			-- migrate:transaction
+			insert into users values (0);
+			with recursive n(i) as (select 1 union all select i + 1 from n)
+			insert into users select i from n;
+		`)},
+		"3_add_index.sql": {Data: []byte(`create index users_id on users(id);`)},
+	}
+
+	// Cancel while the second migration is running forever.
+	cancelled, cancel := context.WithCancel(ctx)
+	defer cancel()
+	time.AfterFunc(100*time.Millisecond, cancel)
+	err = Up(cancelled, source.NewFS(fsys), client, true)
+
+	var ie *InterruptedError
+	if !errors.As(err, &ie) {
+		t.Fatalf("got %v, want InterruptedError", err)
+	}
+	want := &InterruptedError{
+		Completed:  []string{"1_add_users_table"},
+		Stopped:    "2_add_users",
+		NotStarted: []string{"3_add_index"},
+		Err:        context.Canceled,
+	}
+	if !reflect.DeepEqual(ie, want) {
+		t.Errorf("got %+v, want %+v", ie, want)
+	}
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want context.Canceled", err)
+	}
+
+	// Confirm the stopped migration was rolled back and recorded, and the
+	// lock released, so that the next run picks up where it left off.
+	status, err := GetStatus(ctx, source.NewFS(fsys), client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := status[1].State; got != StateFailed {
+		t.Errorf("2_add_users: got %s, want %s", got, StateFailed)
+	}
+	var n int
+	if err := client.DB().QueryRowContext(ctx, `select count(*) from users;`).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("got %d users after rollback, want 0", n)
+	}
+	if ok, err := client.TryLock(ctx); err != nil || !ok {
+		t.Fatalf("lock was not released: %v, %v", ok, err)
+	}
+	if _, err := client.Unlock(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// bookkeepingDriver is a SQLite driver that calls interrupt while
+// recording a migration as applied, and records whether the interrupt
+// cancels the recording, rather than just stopping Up after it.
+type bookkeepingDriver struct {
+	*sqlite.Client
+	interrupt func()
+
+	mu          sync.Mutex
+	recording   bool
+	interrupted bool // CancelStatement was called while recording
+}
+
+func (d *bookkeepingDriver) setRecording(recording bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.recording = recording
+}
+
+func (d *bookkeepingDriver) LogCompletedMigration(ctx context.Context, m *db.Migration) error {
+	d.setRecording(true)
+	defer d.setRecording(false)
+	d.interrupt()
+	time.Sleep(50 * time.Millisecond) // let Up react to the interrupt
+	return d.Client.LogCompletedMigration(ctx, m)
+}
+
+func (d *bookkeepingDriver) CancelStatement(ctx context.Context) error {
+	d.mu.Lock()
+	if d.recording {
+		d.interrupted = true
+	}
+	d.mu.Unlock()
+	return d.Client.CancelStatement(ctx)
+}
+
+func TestUpInterruptedWhileRecording(t *testing.T) {
+	ctx := context.Background()
+	lite, err := sqlite.Open(ctx, "sqlite://"+filepath.Join(t.TempDir(), "test.db"), db.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lite.Close(ctx)
+	fsys := fstest.MapFS{
+		"1_add_users_table.sql": {Data: []byte(`create table users(id int);`)},
+		"2_add_index.sql":       {Data: []byte(`create index users_id on users(id);`)},
+	}
+
+	// Interrupt after the first migration has run, but before it's
+	// recorded.
+	cancelled, cancel := context.WithCancel(ctx)
+	defer cancel()
+	client := &bookkeepingDriver{Client: lite, interrupt: cancel}
+	err = Up(cancelled, source.NewFS(fsys), client, true)
+
+	var ie *InterruptedError
+	if !errors.As(err, &ie) {
+		t.Fatalf("got %v, want InterruptedError", err)
+	}
+	want := &InterruptedError{
+		Completed:  []string{"1_add_users_table"},
+		NotStarted: []string{"2_add_index"},
+		Err:        context.Canceled,
+	}
+	if !reflect.DeepEqual(ie, want) {
+		t.Errorf("got %+v, want %+v", ie, want)
+	}
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if client.interrupted {
+		t.Error("recording the migration was cancelled")
+	}
+
+	// Confirm the migration was recorded, so it isn't run again.
+	status, err := GetStatus(ctx, source.NewFS(fsys), lite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := status[0].State; got != StateApplied {
+		t.Errorf("1_add_users_table: got %s, want %s", got, StateApplied)
+	}
+	if got := status[1].State; got != StatePending {
+		t.Errorf("2_add_index: got %s, want %s", got, StatePending)
+	}
+}
+
+func TestUpInterruptedGoMigration(t *testing.T) {
+	defer func(saved []*GoMigration) { registry = saved }(registry)
+	registry = nil
+
+	ctx := context.Background()
+	client, err := sqlite.Open(ctx, "sqlite://"+filepath.Join(t.TempDir(), "test.db"), db.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close(ctx)
+	fsys := fstest.MapFS{
+		"1_add_users_table.sql": {Data: []byte(`create table users(id int);`)},
+		"3_add_index.sql":       {Data: []byte(`create index users_id on users(id);`)},
+	}
+	var fnErr, ctxErr error
+	Register(2, "add_users", func(ctx context.Context, d db.Driver) error {
+		if err := d.Exec(ctx, `insert into users values (0);`); err != nil {
+			return err
+		}
+		fnErr = d.Exec(ctx, `
+			with recursive n(i) as (select 1 union all select i + 1 from n)
+			insert into users select i from n;
+		`)
+		ctxErr = ctx.Err()
+		return fnErr
+	})
+
+	// Cancel while the Go migration is running forever.
+	cancelled, cancel := context.WithCancel(ctx)
+	defer cancel()
+	time.AfterFunc(100*time.Millisecond, cancel)
+	err = Up(cancelled, source.NewFS(fsys), client, true)
+
+	var ie *InterruptedError
+	if !errors.As(err, &ie) {
+		t.Fatalf("got %v, want InterruptedError", err)
+	}
+	want := &InterruptedError{
+		Completed:  []string{"1_add_users_table"},
+		Stopped:    "2_add_users",
+		NotStarted: []string{"3_add_index"},
+		Err:        context.Canceled,
+	}
+	if !reflect.DeepEqual(ie, want) {
+		t.Errorf("got %+v, want %+v", ie, want)
+	}
+	if fnErr == nil {
+		t.Error("the Go migration's query was not cancelled")
+	}
+	if ctxErr != nil {
+		t.Errorf("the Go migration's context was cancelled: %v", ctxErr)
+	}
+
+	// Confirm the Go migration was recorded as failed, rather than its
+	// connection closed, and the lock released.
+	status, err := GetStatus(ctx, source.NewFS(fsys), client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := status[1].State; got != StateFailed {
+		t.Errorf("2_add_users: got %s, want %s", got, StateFailed)
+	}
+	var n int
+	if err := client.DB().QueryRowContext(ctx, `select count(*) from users;`).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("got %d users, want the 1 inserted before the interrupt", n)
+	}
+	if ok, err := client.TryLock(ctx); err != nil || !ok {
+		t.Fatalf("lock was not released: %v, %v", ok, err)
+	}
+	if _, err := client.Unlock(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,131 @@
+package migrate
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/johngibb/migrate/db"
+	"github.com/johngibb/migrate/source"
+)
+
+// MarkApplied records the migration with the given name as applied,
+// without executing it, e.g. after an operator applied it by hand as a
+// hotfix. It replaces any record of the migration having failed, and is
+// an error if it's already applied, unless it's repeatable. The change is
+// recorded in the audit log, along with who made it and the reason, which
+// is required.
+func MarkApplied(ctx context.Context, src *source.Source, client db.Driver, name, reason string) error {
+	return withManualChange(ctx, src, client, name, reason, func(m *migration, records []*db.Migration) error {
+		for _, a := range records {
+			if !a.Failed && !m.Repeatable {
+				return errors.Errorf("migration is already applied: %s", name)
+			}
+		}
+		checksum, err := m.checksum()
+		if err != nil {
+			return errors.Wrap(err, "error reading migration")
+		}
+		record := newRecord(name, checksum, time.Now(), 0)
+		record.Reason = reason
+		if err := client.LogCompletedMigration(ctx, record); err != nil {
+			return errors.Wrap(err, "error recording migration")
+		}
+		if err := client.LogAudit(ctx, newAuditEntry(name, "mark-applied", reason)); err != nil {
+			return errors.Wrap(err, "error recording audit entry")
+		}
+		log.Printf("Marked %s as applied", name)
+		return nil
+	})
+}
+
+// Unmark deletes every record of the migration with the given name, so
+// that it's considered pending, e.g. after an operator reverted it by
+// hand. The change is recorded in the audit log, along with who made it
+// and the reason, which is required.
+func Unmark(ctx context.Context, src *source.Source, client db.Driver, name, reason string) error {
+	return withManualChange(ctx, src, client, name, reason, func(m *migration, records []*db.Migration) error {
+		if len(records) == 0 {
+			return errors.Errorf("migration has not been applied: %s", name)
+		}
+		if err := client.DeleteMigration(ctx, name); err != nil {
+			return errors.Wrap(err, "error deleting migration")
+		}
+		if err := client.LogAudit(ctx, newAuditEntry(name, "unmark", reason)); err != nil {
+			return errors.Wrap(err, "error recording audit entry")
+		}
+		log.Printf("Unmarked %s", name)
+		return nil
+	})
+}
+
+// withManualChange validates the name and reason for a change to the
+// migrations table made by hand, then calls fn with the migration and its
+// records while holding the lock.
+func withManualChange(
+	ctx context.Context,
+	src *source.Source,
+	client db.Driver,
+	name, reason string,
+	fn func(m *migration, records []*db.Migration) error,
+) (err error) {
+	if reason == "" {
+		return errors.New("a reason is required")
+	}
+	migrations, err := findMigrations(src)
+	if err != nil {
+		return errors.Wrap(err, "error reading migration files")
+	}
+	m := findByName(migrations, name)
+	if m == nil {
+		return errors.Errorf("migration not found: %s", name)
+	}
+
+	if err := lock(ctx, client, 0, &TextSink{Logger: DefaultLogger}); err != nil {
+		return err
+	}
+	defer func() {
+		_, e := client.Unlock(ctx)
+		if err == nil && e != nil {
+			err = e
+		}
+	}()
+
+	applied, err := client.GetMigrations(ctx)
+	if err != nil {
+		return errors.Wrap(err, "error fetching migrations")
+	}
+	var records []*db.Migration
+	for _, a := range applied {
+		if a.Name == name {
+			records = append(records, a)
+		}
+	}
+	return fn(m, records)
+}
+
+// findByName returns the migration with the given name, or nil if there
+// isn't one.
+func findByName(migrations []*migration, name string) *migration {
+	for _, m := range migrations {
+		if m.Name == name {
+			return m
+		}
+	}
+	return nil
+}
+
+// newAuditEntry returns the audit entry to log for a change made by this
+// process.
+func newAuditEntry(name, action, reason string) *db.AuditEntry {
+	return &db.AuditEntry{
+		Name:     name,
+		Action:   action,
+		At:       time.Now(),
+		By:       currentUser(),
+		Hostname: hostname(),
+		Reason:   reason,
+	}
+}
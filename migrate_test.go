@@ -1,9 +1,13 @@
 package migrate
 
 import (
+	"bytes"
 	"context"
+	"database/sql"
+	"encoding/json"
 	"fmt"
 	"log"
+	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -14,6 +18,12 @@ import (
 	"time"
 
 	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	_ "github.com/jackc/pgx/v4/stdlib"
+	"github.com/pkg/errors"
+
+	"github.com/johngibb/migrate/db"
+	"github.com/johngibb/migrate/source"
 )
 
 var (
@@ -55,6 +65,96 @@ Running 2_add_orders_table:
 	}
 }
 
+func TestMigrateUpDryRun(t *testing.T) {
+	ctx := context.Background()
+	setup(ctx, t)
+	createMigration(ctx, "1_add_users_table.sql", `
+		create table users(id int);
+		create index on users(id);
+	`)
+
+	// Plan the migration.
+	out := mustRun("migrate up --src ./migrations --conn %s --dry-run", connectionString)
+	want := `-- 1_add_users_table
+create table users(id int);
+create index on users(id);
+`
+	if !strings.Contains(out, want) {
+		t.Errorf("output: want:\n%v\n\ngot:\n%s", want, out)
+	}
+
+	// Confirm nothing was executed.
+	out = mustRun("migrate status --src ./migrations --conn %s", connectionString)
+	if want := "1_add_users_table pending"; !strings.Contains(out, want) {
+		t.Errorf("output missing: %q", want)
+	}
+}
+
+func TestMigrateUpTo(t *testing.T) {
+	ctx := context.Background()
+	setup(ctx, t)
+	createMigration(ctx, "1_add_users_table.sql", "create table users(id int);")
+	createMigration(ctx, "2_add_posts_table.sql", "create table posts(id int);")
+	createMigration(ctx, "3_add_likes_table.sql", "create table likes(id int);")
+
+	// Apply up to the second migration, by version.
+	mustRun("migrate up --src ./migrations --conn %s --to 2", connectionString)
+	out := mustRun("migrate status --src ./migrations --conn %s", connectionString)
+	for _, want := range []string{
+		"1_add_users_table applied",
+		"2_add_posts_table applied",
+		"3_add_likes_table pending",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing: %q", want)
+		}
+	}
+
+	// Confirm an unknown target is rejected.
+	out, err := run("migrate up --src ./migrations --conn %s --to 4_missing", connectionString)
+	if err == nil {
+		t.Fatal("error was nil")
+	}
+	if want := "target migration not found: 4_missing"; !strings.Contains(out, want) {
+		t.Errorf("output missing: %q", want)
+	}
+}
+
+func TestMigrateUpJSON(t *testing.T) {
+	ctx := context.Background()
+	setup(ctx, t)
+	createMigration(ctx, "1_add_users_table.sql", "create table users(id int);")
+
+	// Run the migration, printing events as JSON lines.
+	out := mustRun("migrate up --src ./migrations --conn %s --format json", connectionString)
+
+	// Verify the sequence of events.
+	var types []string
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		var e struct {
+			Type       string   `json:"type"`
+			Migration  string   `json:"migration"`
+			DurationMS *float64 `json:"duration_ms"`
+		}
+		must(json.Unmarshal([]byte(line), &e), "error parsing event")
+		if e.Type == "statement_finished" && e.DurationMS == nil {
+			t.Errorf("statement_finished missing duration: %s", line)
+		}
+		types = append(types, e.Type)
+	}
+	want := []string{
+		"lock_acquired",
+		"migration_started",
+		"statement_started",
+		"statement_finished",
+		"migration_finished",
+		"lock_released",
+	}
+	if strings.Join(types, ",") != strings.Join(want, ",") {
+		t.Errorf("events: got %v, want %v", types, want)
+	}
+}
+
 func TestMigrateUpQuietNoError(t *testing.T) {
 	ctx := context.Background()
 	setup(ctx, t)
@@ -93,6 +193,339 @@ migrate: ERROR: syntax error at or near "invalid" \(SQLSTATE 42601\)
 	}
 }
 
+func TestMigrateUpTransaction(t *testing.T) {
+	ctx := context.Background()
+	setup(ctx, t)
+	// Create a transactional migration that fails partway through.
+	createMigration(ctx, "1_add_users_table.sql", `
+		-- migrate:transaction
+		create table users(id int);
+		invalid sql statement;
+	`)
+
+	// Confirm the migration failed and was rolled back.
+	out, err := run("migrate up --src ./migrations --conn %s", connectionString)
+	if err == nil {
+		t.Fatal("error was nil")
+	}
+	if want := "Running 1_add_users_table (in transaction):"; !strings.Contains(out, want) {
+		t.Errorf("output missing: %q", want)
+	}
+	cfg, err := pgx.ParseConfig(connectionString)
+	must(err, "error parsing connection uri")
+	conn, err := pgx.ConnectConfig(ctx, cfg)
+	must(err, "error connecting to database")
+	defer conn.Close(ctx)
+	var exists bool
+	err = conn.QueryRow(ctx, `select to_regclass('users') is not null`).Scan(&exists)
+	must(err, "error querying tables")
+	if exists {
+		t.Error("users table exists after rollback")
+	}
+
+	// Fix the migration, and confirm it applies.
+	createMigration(ctx, "1_add_users_table.sql", `
+		-- migrate:transaction
+		create table users(id int);
+		create index on users(id);
+	`)
+	mustRun("migrate up --src ./migrations --conn %s", connectionString)
+	out = mustRun("migrate status --src ./migrations --conn %s", connectionString)
+	if want := "1_add_users_table applied"; !strings.Contains(out, want) {
+		t.Errorf("output missing: %q", want)
+	}
+}
+
+func TestMigrateUpLockRetries(t *testing.T) {
+	ctx := context.Background()
+	setup(ctx, t)
+	createMigration(ctx, "1_add_users_table.sql", `create table users(id int);`)
+	mustRun("migrate up --src ./migrations --conn %s", connectionString)
+	createMigration(ctx, "2_add_name.sql", `
+		-- migrate:pg-lock-timeout 100ms
+		alter table users add column name text;
+	`)
+
+	// Hold a lock on the table for a while, as a long-running query would.
+	cfg, err := pgx.ParseConfig(connectionString)
+	must(err, "error parsing connection uri")
+	conn, err := pgx.ConnectConfig(ctx, cfg)
+	must(err, "error connecting to database")
+	defer conn.Close(ctx)
+	tx, err := conn.Begin(ctx)
+	must(err, "error beginning transaction")
+	_, err = tx.Exec(ctx, `lock table users in access share mode;`)
+	must(err, "error locking table")
+	go func() {
+		time.Sleep(500 * time.Millisecond)
+		tx.Rollback(ctx)
+	}()
+
+	// Confirm the migration times out waiting, then succeeds on a retry.
+	out := mustRun("migrate up --src ./migrations --conn %s --lock-retries 5 --lock-retry-delay 100ms", connectionString)
+	if want := "lock timeout; retrying in 100ms (1 of 5)"; !strings.Contains(out, want) {
+		t.Errorf("output missing: %q\n%s", want, out)
+	}
+	out = mustRun("migrate status --src ./migrations --conn %s", connectionString)
+	if want := "2_add_name applied"; !strings.Contains(out, want) {
+		t.Errorf("output missing: %q", want)
+	}
+}
+
+func TestMigrateUpNoSplit(t *testing.T) {
+	ctx := context.Background()
+	setup(ctx, t)
+	createMigration(ctx, "1_add_users_table.sql", `
+		-- migrate:no-split
+		create table users(id int);
+		create function add_user(id int) returns void as $body$
+		begin
+			insert into users values (id);
+		end
+		$body$ language plpgsql;
+		select add_user(1);
+	`)
+
+	// Confirm the file is run, and reported, as a single statement.
+	out := mustRun("migrate up --src ./migrations --conn %s", connectionString)
+	if n := strings.Count(out, "=> OK"); n != 1 {
+		t.Errorf("got %d statements, want 1:\n%s", n, out)
+	}
+	cfg, err := pgx.ParseConfig(connectionString)
+	must(err, "error parsing connection uri")
+	conn, err := pgx.ConnectConfig(ctx, cfg)
+	must(err, "error connecting to database")
+	defer conn.Close(ctx)
+	var count int
+	err = conn.QueryRow(ctx, `select count(*) from users`).Scan(&count)
+	must(err, "error querying users")
+	if count != 1 {
+		t.Errorf("got %d users, want 1", count)
+	}
+}
+
+func TestGoMigration(t *testing.T) {
+	ctx := context.Background()
+	setup(ctx, t)
+	defer func(saved []*GoMigration) { registry = saved }(registry)
+	registry = nil
+
+	createMigration(ctx, "1_add_users_table.sql", `create table users(id int, name text);`)
+	createMigration(ctx, "3_add_index.sql", `create index on users(name);`)
+	Register(2, "backfill_names", func(ctx context.Context, d db.Driver) error {
+		_, err := d.(*db.Client).Conn().Exec(ctx, `insert into users values (1, $1);`, "alice")
+		return err
+	})
+
+	src, err := source.New("./migrations")
+	must(err, "error opening migrations")
+	client, err := db.Connect(ctx, connectionString)
+	must(err, "error connecting to database")
+	defer client.Close(ctx)
+	var buf bytes.Buffer
+	err = UpWithOptions(ctx, src, client, UpOptions{Events: NewJSONSink(&buf)})
+	must(err, "error running migrations")
+
+	// Confirm the migrations ran in version order.
+	var started []string
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var e Event
+		must(json.Unmarshal([]byte(line), &e), "error parsing event")
+		if e.Type == EventMigrationStarted {
+			started = append(started, e.Migration)
+		}
+	}
+	if want := []string{"1_add_users_table", "2_backfill_names", "3_add_index"}; strings.Join(started, ",") != strings.Join(want, ",") {
+		t.Errorf("got %v, want %v", started, want)
+	}
+
+	// Confirm the Go migration is recorded.
+	entries, err := GetStatus(ctx, src, client)
+	must(err, "error getting status")
+	if len(entries) != 3 || entries[1].Name != "2_backfill_names" || entries[1].State != StateApplied {
+		b, _ := json.Marshal(entries)
+		t.Errorf("unexpected status: %s", b)
+	}
+}
+
+func TestMigrateRepeatable(t *testing.T) {
+	ctx := context.Background()
+	setup(ctx, t)
+	createMigration(ctx, "R__user_names.sql", `create or replace view user_names as select name from users;`)
+	createMigration(ctx, "1_add_users_table.sql", `create table users(id int, name text);`)
+
+	// Confirm the repeatable migration runs after the versioned one.
+	out := mustRun("migrate up --src ./migrations --conn %s", connectionString)
+	if i, j := strings.Index(out, "Running 1_add_users_table"), strings.Index(out, "Running R__user_names"); i == -1 || j < i {
+		t.Errorf("repeatable migration not run last:\n%s", out)
+	}
+	out = mustRun("migrate status --src ./migrations --conn %s", connectionString)
+	if want := regexp.MustCompile(`R__user_names +applied .*, checksum [0-9a-f]{12}`); !want.MatchString(out) {
+		t.Errorf("output: want:\n%v\n\ngot:\n%s", want, out)
+	}
+
+	// Confirm it's only run again once it changes.
+	out = mustRun("migrate up --src ./migrations --conn %s", connectionString)
+	if want := "nothing to do"; !strings.Contains(out, want) {
+		t.Errorf("output missing: %q", want)
+	}
+	createMigration(ctx, "R__user_names.sql", `create or replace view user_names as select name, id from users;`)
+	out = mustRun("migrate status --src ./migrations --conn %s", connectionString)
+	if want := "R__user_names     pending, last applied"; !strings.Contains(out, want) {
+		t.Errorf("output missing: %q", want)
+	}
+	out = mustRun("migrate up --src ./migrations --conn %s", connectionString)
+	if want := "Running R__user_names"; !strings.Contains(out, want) {
+		t.Errorf("output missing: %q", want)
+	}
+	out = mustRun("migrate status --src ./migrations --conn %s", connectionString)
+	if want := "R__user_names     applied"; !strings.Contains(out, want) {
+		t.Errorf("output missing: %q", want)
+	}
+}
+
+func TestMigrateUpPartialFailure(t *testing.T) {
+	ctx := context.Background()
+	setup(ctx, t)
+	// Create a migration that fails on its second statement.
+	createMigration(ctx, "1_add_users_table.sql", `
+		create table users(id int);
+		invalid sql statement;
+	`)
+	if _, err := run("migrate up --src ./migrations --conn %s", connectionString); err == nil {
+		t.Fatal("error was nil")
+	}
+
+	// Confirm the failure is reported, and blocks retrying.
+	out := mustRun("migrate status --src ./migrations --conn %s", connectionString)
+	if want := "1_add_users_table failed at statement 2"; !strings.Contains(out, want) {
+		t.Errorf("output missing: %q", want)
+	}
+	out, err := run("migrate up --src ./migrations --conn %s", connectionString)
+	if err == nil {
+		t.Fatal("error was nil")
+	}
+	if want := "previously failed at statement 2"; !strings.Contains(out, want) {
+		t.Errorf("output missing: %q", want)
+	}
+
+	// Clean up by hand, clear the failure, and confirm it's retried.
+	cfg, err := pgx.ParseConfig(connectionString)
+	must(err, "error parsing connection uri")
+	conn, err := pgx.ConnectConfig(ctx, cfg)
+	must(err, "error connecting to database")
+	defer conn.Close(ctx)
+	_, err = conn.Exec(ctx, "drop table users")
+	must(err, "error dropping table")
+	createMigration(ctx, "1_add_users_table.sql", `
+		create table users(id int);
+		create index on users(id);
+	`)
+	mustRun("migrate resolve --src ./migrations --conn %s --clear 1_add_users_table", connectionString)
+	mustRun("migrate up --src ./migrations --conn %s", connectionString)
+	out = mustRun("migrate status --src ./migrations --conn %s", connectionString)
+	if want := "1_add_users_table applied"; !strings.Contains(out, want) {
+		t.Errorf("output missing: %q", want)
+	}
+}
+
+func TestMigrateResolveApplied(t *testing.T) {
+	ctx := context.Background()
+	setup(ctx, t)
+	createMigration(ctx, "1_add_users_table.sql", `
+		create table users(id int);
+		invalid sql statement;
+	`)
+	if _, err := run("migrate up --src ./migrations --conn %s", connectionString); err == nil {
+		t.Fatal("error was nil")
+	}
+
+	// Mark the failed migration as applied.
+	mustRun("migrate resolve --src ./migrations --conn %s --applied 1_add_users_table", connectionString)
+	out := mustRun("migrate status --src ./migrations --conn %s", connectionString)
+	if want := "1_add_users_table applied"; !strings.Contains(out, want) {
+		t.Errorf("output missing: %q", want)
+	}
+}
+
+func TestMigrateBaseline(t *testing.T) {
+	ctx := context.Background()
+	setup(ctx, t)
+	createMigration(ctx, "1_add_users_table.sql", "create table users(id int);")
+	createMigration(ctx, "2_add_posts_table.sql", "create table posts(id int);")
+	createMigration(ctx, "3_add_likes_table.sql", "create table likes(id int);")
+
+	// Baseline the first two migrations, which are not executed.
+	out := mustRun("migrate baseline --src ./migrations --conn %s --to 2", connectionString)
+	for _, want := range []string{"Baselined 1_add_users_table", "Baselined 2_add_posts_table"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing: %q", want)
+		}
+	}
+	out = mustRun("migrate status --src ./migrations --conn %s", connectionString)
+	for _, want := range []string{
+		"1_add_users_table applied (baselined)",
+		"2_add_posts_table applied (baselined)",
+		"3_add_likes_table pending",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing: %q", want)
+		}
+	}
+
+	// Confirm only the remaining migration is run.
+	out = mustRun("migrate up --src ./migrations --conn %s", connectionString)
+	if strings.Contains(out, "Running 1_add_users_table") || !strings.Contains(out, "Running 3_add_likes_table") {
+		t.Errorf("unexpected output:\n%s", out)
+	}
+}
+
+func TestMigrateMarkApplied(t *testing.T) {
+	ctx := context.Background()
+	setup(ctx, t)
+	createMigration(ctx, "1_add_users_table.sql", "create table users(id int);")
+
+	// Mark the migration as applied, and confirm it's not run.
+	mustRun("migrate mark-applied --src ./migrations --conn %s --reason hotfix 1_add_users_table", connectionString)
+	out := mustRun("migrate status --src ./migrations --conn %s", connectionString)
+	if want := regexp.MustCompile(`1_add_users_table applied \(marked\) .*: hotfix`); !want.MatchString(out) {
+		t.Errorf("output: want:\n%v\n\ngot:\n%s", want, out)
+	}
+	out = mustRun("migrate up --src ./migrations --conn %s", connectionString)
+	if want := "nothing to do"; !strings.Contains(out, want) {
+		t.Errorf("output missing: %q", want)
+	}
+
+	// Unmark it, and confirm it's run.
+	mustRun("migrate unmark --src ./migrations --conn %s --reason reverted 1_add_users_table", connectionString)
+	out = mustRun("migrate up --src ./migrations --conn %s", connectionString)
+	if want := "Running 1_add_users_table"; !strings.Contains(out, want) {
+		t.Errorf("output missing: %q", want)
+	}
+
+	// Confirm both changes were audited.
+	client, err := db.Connect(ctx, connectionString)
+	must(err, "error connecting to database")
+	defer client.Close(ctx)
+	entries, err := client.GetAuditLog(ctx)
+	must(err, "error fetching audit log")
+	var actions []string
+	for _, e := range entries {
+		actions = append(actions, e.Action+": "+e.Reason)
+	}
+	if want := []string{"mark-applied: hotfix", "unmark: reverted"}; strings.Join(actions, ",") != strings.Join(want, ",") {
+		t.Errorf("audit log: got %v, want %v", actions, want)
+	}
+
+	// Confirm unknown migrations, and missing reasons, are rejected.
+	if _, err := run("migrate mark-applied --src ./migrations --conn %s --reason x 2_missing", connectionString); err == nil {
+		t.Error("marking an unknown migration: error was nil")
+	}
+	if _, err := run("migrate unmark --src ./migrations --conn %s 1_add_users_table", connectionString); err == nil {
+		t.Error("unmarking without a reason: error was nil")
+	}
+}
+
 func TestMigrateCreate(t *testing.T) {
 	ctx := context.Background()
 	setup(ctx, t)
@@ -107,6 +540,23 @@ func TestMigrateCreate(t *testing.T) {
 	}
 }
 
+func TestMigrateLint(t *testing.T) {
+	ctx := context.Background()
+	setup(ctx, t)
+	createMigration(ctx, "20200101000000_add_users_table.sql", "create table users(id int);")
+	mustRun("migrate lint --src ./migrations")
+
+	// Add a problematic migration, and confirm it's reported.
+	createMigration(ctx, "20200101000000_add_posts_table.sql", "create table posts(id int);")
+	out, err := run("migrate lint --src ./migrations")
+	if err == nil {
+		t.Fatal("error was nil")
+	}
+	if want := "version 20200101000000 is shared with"; !strings.Contains(out, want) {
+		t.Errorf("output missing: %q", want)
+	}
+}
+
 func TestMigrateStatus(t *testing.T) {
 	ctx := context.Background()
 	setup(ctx, t)
@@ -150,6 +600,195 @@ func TestMigrateStatusAndUp(t *testing.T) {
 	}
 }
 
+func TestMigrateRecordsMetadata(t *testing.T) {
+	ctx := context.Background()
+	setup(ctx, t)
+	createMigration(ctx, "1_add_users_table.sql", "create table users(id int);")
+	mustRun("migrate up --src ./migrations --conn %s", connectionString)
+
+	// Confirm the migration was recorded with its metadata.
+	cfg, err := pgx.ParseConfig(connectionString)
+	must(err, "error parsing connection uri")
+	conn, err := pgx.ConnectConfig(ctx, cfg)
+	must(err, "error connecting to database")
+	defer conn.Close(ctx)
+	var (
+		appliedAt          time.Time
+		checksum, appliers string
+	)
+	err = conn.QueryRow(ctx, `select applied_at, checksum, applied_by || '@' || hostname from migrations where name = '1_add_users_table'`).
+		Scan(&appliedAt, &checksum, &appliers)
+	must(err, "error querying migrations")
+	if appliedAt.IsZero() {
+		t.Error("applied_at not recorded")
+	}
+	if len(checksum) != 64 {
+		t.Errorf("checksum: want sha256 hex, got %q", checksum)
+	}
+	if appliers == "@" {
+		t.Error("applied_by and hostname not recorded")
+	}
+}
+
+func TestMigrateUpgradesLegacyTable(t *testing.T) {
+	ctx := context.Background()
+	setup(ctx, t)
+	createMigration(ctx, "1_add_users_table.sql", "create table users(id int);")
+	createMigration(ctx, "2_add_posts_table.sql", "create table posts(id int);")
+
+	// Create a migrations table as written by older versions.
+	cfg, err := pgx.ParseConfig(connectionString)
+	must(err, "error parsing connection uri")
+	conn, err := pgx.ConnectConfig(ctx, cfg)
+	must(err, "error connecting to database")
+	defer conn.Close(ctx)
+	_, err = conn.Exec(ctx, `
+		create table users(id int);
+		create table migrations(name text);
+		insert into migrations values ('1_add_users_table');
+	`)
+	must(err, "error creating legacy migrations table")
+
+	// Confirm the legacy record is still honored after the upgrade.
+	out := mustRun("migrate up --src ./migrations --conn %s", connectionString)
+	if strings.Contains(out, "Running 1_add_users_table") {
+		t.Errorf("legacy migration was re-applied:\n%s", out)
+	}
+	out = mustRun("migrate status --src ./migrations --conn %s", connectionString)
+	if want := "1_add_users_table applied"; !strings.Contains(out, want) {
+		t.Errorf("output missing: %q", want)
+	}
+	if want := "2_add_posts_table applied"; !strings.Contains(out, want) {
+		t.Errorf("output missing: %q", want)
+	}
+}
+
+func TestMigrateModified(t *testing.T) {
+	ctx := context.Background()
+	setup(ctx, t)
+	createMigration(ctx, "1_add_users_table.sql", "create table users(id int);")
+	mustRun("migrate up --src ./migrations --conn %s", connectionString)
+
+	// Edit the applied migration.
+	createMigration(ctx, "1_add_users_table.sql", "create table users(id bigint);")
+
+	// Confirm it is reported as modified, and that up refuses to run.
+	out := mustRun("migrate status --src ./migrations --conn %s", connectionString)
+	if want := "1_add_users_table modified"; !strings.Contains(out, want) {
+		t.Errorf("output missing: %q", want)
+	}
+	out, err := run("migrate up --src ./migrations --conn %s", connectionString)
+	if err == nil {
+		t.Fatal("error was nil")
+	}
+	if want := "applied migrations have been modified: 1_add_users_table"; !strings.Contains(out, want) {
+		t.Errorf("output missing: %q", want)
+	}
+
+	// Accept the edit, and confirm the checksum was re-stamped.
+	mustRun("migrate up --src ./migrations --conn %s --allow-modified", connectionString)
+	out = mustRun("migrate status --src ./migrations --conn %s", connectionString)
+	if want := "1_add_users_table applied"; !strings.Contains(out, want) {
+		t.Errorf("output missing: %q", want)
+	}
+}
+
+func TestMigrateCustomTable(t *testing.T) {
+	ctx := context.Background()
+	setup(ctx, t)
+	createMigration(ctx, "1_add_users_table.sql", "create table users(id int);")
+
+	// Apply the migration, recording it in a custom table.
+	mustRun("migrate up --src ./migrations --conn %s --table schema_migrations", connectionString)
+
+	// Confirm it's applied according to the custom table only.
+	out := mustRun("migrate status --src ./migrations --conn %s --table schema_migrations", connectionString)
+	if want := "1_add_users_table applied"; !strings.Contains(out, want) {
+		t.Errorf("output missing: %q", want)
+	}
+	out = mustRun("migrate status --src ./migrations --conn %s", connectionString)
+	if want := "1_add_users_table pending"; !strings.Contains(out, want) {
+		t.Errorf("output missing: %q", want)
+	}
+
+	// Confirm the same table name in another schema is tracked
+	// independently.
+	defer dropSchema(ctx, "other")
+	out = mustRun("migrate status --src ./migrations --conn %s --schema other --table schema_migrations", connectionString)
+	if want := "1_add_users_table pending"; !strings.Contains(out, want) {
+		t.Errorf("output missing: %q", want)
+	}
+}
+
+func TestMigrateStatusJSON(t *testing.T) {
+	ctx := context.Background()
+	setup(ctx, t)
+	createMigration(ctx, "1_add_users_table.sql", "create table users(id int);")
+	createMigration(ctx, "2_add_posts_table.sql", "create table posts(id int);")
+	mustRun("migrate up --src ./migrations --conn %s --to 1", connectionString)
+
+	// Confirm pending migrations produce exit code 3.
+	out, err := run("migrate status --src ./migrations --conn %s --format json --exit-code", connectionString)
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 3 {
+		t.Fatalf("got %v, want exit status 3", err)
+	}
+	var entries []struct {
+		Name      string     `json:"name"`
+		Version   int        `json:"version"`
+		State     string     `json:"state"`
+		AppliedAt *time.Time `json:"applied_at"`
+	}
+	must(json.Unmarshal([]byte(out), &entries), "error parsing output")
+	if len(entries) != 2 {
+		t.Fatalf("entries: want 2, got %d:\n%s", len(entries), out)
+	}
+	if e := entries[0]; e.Name != "1_add_users_table" || e.Version != 1 || e.State != "applied" || e.AppliedAt == nil {
+		t.Errorf("entry 0: got %+v", e)
+	}
+	if e := entries[1]; e.Name != "2_add_posts_table" || e.Version != 2 || e.State != "pending" || e.AppliedAt != nil {
+		t.Errorf("entry 1: got %+v", e)
+	}
+
+	// Confirm the exit code is zero once they're applied.
+	mustRun("migrate up --src ./migrations --conn %s", connectionString)
+	mustRun("migrate status --src ./migrations --conn %s --format json --exit-code", connectionString)
+}
+
+func TestMigrateExistingSchema(t *testing.T) {
+	ctx := context.Background()
+	setup(ctx, t)
+	createMigration(ctx, "1_add_users_table.sql", "create table service.users(id int);")
+
+	// Create a role that owns its schema, but can't create schemas in the
+	// database, as when services share a database.
+	conn, err := pgx.Connect(ctx, connectionString)
+	must(err, "error connecting to database")
+	defer conn.Close(ctx)
+	dropRole := func() {
+		dropSchema(ctx, "service")
+		_, err := conn.Exec(ctx, `drop role if exists migrate_service;`)
+		must(err, "error dropping role")
+	}
+	dropRole()
+	defer dropRole()
+	_, err = conn.Exec(ctx, `
+		create role migrate_service login password 'service';
+		create schema service authorization migrate_service;
+	`)
+	must(err, "error creating role")
+	u, err := url.Parse(connectionString)
+	must(err, "error parsing connection uri")
+	u.User = url.UserPassword("migrate_service", "service")
+
+	// Confirm the migrations table is created in the existing schema.
+	mustRun("migrate up --src ./migrations --conn %s --schema service", u.String())
+	out := mustRun("migrate status --src ./migrations --conn %s --schema service", u.String())
+	if want := "1_add_users_table applied"; !strings.Contains(out, want) {
+		t.Errorf("output missing: %q", want)
+	}
+}
+
 func TestDSN(t *testing.T) {
 	ctx := context.Background()
 	setup(ctx, t)
@@ -211,6 +850,127 @@ func TestLocking(t *testing.T) {
 	}
 }
 
+func TestLockTimeout(t *testing.T) {
+	ctx := context.Background()
+	setup(ctx, t)
+	createMigration(ctx, "1_add_users_table.sql", "select pg_sleep(1);")
+
+	// Run the migration twice in parallel, waiting for the lock.
+	out := make([]string, 2)
+	errs := make([]error, 2)
+	var wg sync.WaitGroup
+	wg.Add(2)
+	for i := 0; i < 2; i++ {
+		go func(i int) {
+			out[i], errs[i] = run("migrate up --src ./migrations --conn %s --lock-timeout 10s", connectionString)
+			wg.Done()
+		}(i)
+	}
+	wg.Wait()
+
+	// Look for two successes, one of which waited for the other.
+	var foundWait bool
+	for i, s := range out {
+		if errs[i] != nil {
+			t.Errorf("command failed: %v\n%s", errs[i], s)
+		}
+		if strings.Contains(s, "Waiting for lock held by pid") {
+			foundWait = true
+		}
+	}
+	if !foundWait {
+		t.Error("neither command waited for the lock")
+	}
+}
+
+func TestPooledClients(t *testing.T) {
+	ctx := context.Background()
+	setup(ctx, t)
+	createMigration(ctx, "1_add_users_table.sql", `create table users(id int);`)
+	createMigration(ctx, "2_add_index.sql", `create index on users(id);`)
+	src, err := source.New("./migrations")
+	must(err, "error opening migrations")
+
+	cfg, err := pgxpool.ParseConfig(connectionString)
+	must(err, "error parsing connection uri")
+	cfg.MaxConns = 1
+	pool, err := pgxpool.ConnectConfig(ctx, cfg)
+	must(err, "error connecting to database")
+	defer pool.Close()
+	sqlDB, err := sql.Open("pgx", connectionString)
+	must(err, "error connecting to database")
+	defer sqlDB.Close()
+
+	connect := map[string]func() (*db.Client, error){
+		"pool": func() (*db.Client, error) { return db.FromPool(ctx, pool, db.Options{}) },
+		"sql":  func() (*db.Client, error) { return db.FromDB(ctx, sqlDB, db.Options{}) },
+	}
+	for name, connect := range connect {
+		client, err := connect()
+		must(err, "error acquiring connection")
+		must(Up(ctx, src, client, true), "error running migrations")
+
+		// Close while holding the lock, and confirm it isn't handed on
+		// with the connection.
+		locked, err := client.TryLock(ctx)
+		must(err, "error acquiring lock")
+		if !locked {
+			t.Fatalf("%s: could not acquire lock", name)
+		}
+		must(client.Close(ctx), "error releasing connection")
+		other, err := db.Connect(ctx, connectionString)
+		must(err, "error connecting to database")
+		locked, err = other.TryLock(ctx)
+		must(err, "error acquiring lock")
+		if !locked {
+			t.Errorf("%s: lock was not released with the connection", name)
+		}
+		other.Close(ctx)
+	}
+	out := mustRun("migrate status --src ./migrations --conn %s", connectionString)
+	if want := "2_add_index applied"; !strings.Contains(out, want) {
+		t.Errorf("output missing: %q", want)
+	}
+}
+
+func TestMigrateUpInterrupted(t *testing.T) {
+	ctx := context.Background()
+	setup(ctx, t)
+	createMigration(ctx, "1_add_users_table.sql", "create table users(id int);")
+	createMigration(ctx, "2_wait.sql", "select pg_sleep(60);")
+	createMigration(ctx, "3_add_posts_table.sql", "create table posts(id int);")
+
+	// Interrupt the migration while it's sleeping.
+	parts := splitCMD(fmt.Sprintf("migrate up --src ./migrations --conn %s", connectionString))
+	cmd := exec.Command(parts[0], parts[1:]...)
+	var out bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &out, &out
+	must(cmd.Start(), "error starting migrate")
+	time.Sleep(time.Second)
+	must(cmd.Process.Signal(os.Interrupt), "error interrupting migrate")
+	if err := cmd.Wait(); err == nil {
+		t.Fatal("error was nil")
+	}
+	want := "interrupted (context canceled); applied: 1_add_users_table; stopped partway: 2_wait; not started: 3_add_posts_table"
+	if !strings.Contains(out.String(), want) {
+		t.Errorf("output missing: %q\n%s", want, out.String())
+	}
+
+	// Confirm the statement was cancelled, and the lock released.
+	out2 := mustRun("migrate status --src ./migrations --conn %s", connectionString)
+	if want := "2_wait failed"; !strings.Contains(out2, want) {
+		t.Errorf("output missing: %q\n%s", want, out2)
+	}
+	client, err := db.Connect(ctx, connectionString)
+	must(err, "error connecting to database")
+	defer client.Close(ctx)
+	locked, err := client.TryLock(ctx)
+	must(err, "error acquiring lock")
+	if !locked {
+		t.Error("lock was not released")
+	}
+}
+
 func TestLegacyCommandLineArgs(t *testing.T) {
 	ctx := context.Background()
 	setup(ctx, t)
@@ -288,6 +1048,17 @@ func resetDB(ctx context.Context) {
 	}
 }
 
+// dropSchema drops the schema, and everything in it.
+func dropSchema(ctx context.Context, schema string) {
+	cfg, err := pgx.ParseConfig(connectionString)
+	must(err, "error parsing connection uri")
+	conn, err := pgx.ConnectConfig(ctx, cfg)
+	must(err, "error connecting to database")
+	defer conn.Close(ctx)
+	_, err = conn.Exec(ctx, "drop schema if exists "+pgx.Identifier{schema}.Sanitize()+" cascade")
+	must(err, "error dropping schema")
+}
+
 // createMigration creates a new migration with the given name in the "migrations" folder.
 func createMigration(ctx context.Context, name, source string) {
 	f, err := os.Create("./migrations/" + name)
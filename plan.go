@@ -0,0 +1,65 @@
+package migrate
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/johngibb/migrate/db"
+	"github.com/johngibb/migrate/source"
+)
+
+// PlannedMigration is a pending migration, and the statements that Up
+// would execute to apply it.
+type PlannedMigration struct {
+	Name       string
+	Statements []string
+
+	// Go is set for Go migrations, which have no statements.
+	Go bool
+}
+
+// Plan determines what Up would do with the same options, without
+// executing anything: it acquires the lock, and returns every pending
+// migration along with its statements, in the order they would run.
+// Quiet is ignored, and AllowModified permits planning despite modified
+// migrations, but does not re-stamp their checksums.
+func Plan(ctx context.Context, src *source.Source, client db.Driver, opts UpOptions) (result []*PlannedMigration, err error) {
+	migrations, err := findMigrations(src)
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading migration files")
+	}
+
+	// Hold the lock while planning, so the result reflects what Up would
+	// do if run now.
+	if err := lock(ctx, client, opts.LockTimeout, opts.events()); err != nil {
+		return nil, err
+	}
+	defer func() {
+		_, e := client.Unlock(ctx)
+		if err == nil && e != nil {
+			err = e
+		}
+	}()
+
+	pending, _, err := findPending(ctx, migrations, client, opts)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range pending {
+		directives, err := m.directives()
+		if err != nil {
+			return nil, errors.Wrap(err, "error reading migration")
+		}
+		stmts, err := m.statements(directives)
+		if err != nil {
+			return nil, errors.Wrap(err, "error reading migration")
+		}
+		result = append(result, &PlannedMigration{
+			Name:       m.Name,
+			Statements: stmts,
+			Go:         m.fn != nil,
+		})
+	}
+	return result, nil
+}
@@ -0,0 +1,62 @@
+package migrate
+
+import (
+	"os"
+	"os/user"
+	"runtime/debug"
+	"time"
+
+	"github.com/johngibb/migrate/db"
+)
+
+const modulePath = "github.com/johngibb/migrate"
+
+// Version returns the version of migrate, as recorded in the build info
+// of the running binary, or "(devel)" if it is unknown.
+func Version() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "(devel)"
+	}
+	if info.Main.Path == modulePath {
+		return info.Main.Version
+	}
+	for _, dep := range info.Deps {
+		if dep.Path == modulePath {
+			if dep.Replace != nil {
+				return dep.Replace.Version
+			}
+			return dep.Version
+		}
+	}
+	return "(devel)"
+}
+
+// newRecord returns the record to log for a migration applied by this
+// process.
+func newRecord(name, checksum string, appliedAt time.Time, elapsed time.Duration) *db.Migration {
+	return &db.Migration{
+		Name:        name,
+		AppliedAt:   appliedAt,
+		Duration:    elapsed,
+		Checksum:    checksum,
+		ToolVersion: Version(),
+		AppliedBy:   currentUser(),
+		Hostname:    hostname(),
+	}
+}
+
+// currentUser returns the name of the OS user running the process.
+func currentUser() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
+
+// hostname returns the host name reported by the kernel, or "" if it
+// is unavailable.
+func hostname() string {
+	h, _ := os.Hostname()
+	return h
+}
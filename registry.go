@@ -0,0 +1,166 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/johngibb/migrate/db"
+	"github.com/johngibb/migrate/source"
+)
+
+// GoMigrationFunc applies a Go migration. It's given the driver that
+// migrations are run on, which holds the migration lock; for Postgres, this
+// is a *db.Client, whose Conn method returns the underlying connection.
+type GoMigrationFunc func(ctx context.Context, d db.Driver) error
+
+// GoMigration is a migration written in Go, rather than SQL, e.g. to
+// backfill data using application code.
+type GoMigration struct {
+	Version int
+	Name    string // <version>_<name>, like the name of a SQL migration
+	Func    GoMigrationFunc
+}
+
+var (
+	registryMu sync.Mutex
+	registry   []*GoMigration
+)
+
+// Register registers a Go migration with the given version and name, to
+// be applied by Up along with the migrations in the source, in version
+// order. It is recorded in the migrations table, and shown by Status,
+// under the name <version>_<name>.
+//
+// Go migrations never run in a transaction, and are retried in full if
+// they fail, so they should either be idempotent, or begin their own
+// transaction.
+//
+// The context a Go migration is given isn't cancelled when Up's is, since
+// that would close the connection before the outcome is recorded. Instead,
+// the query it's running when Up is interrupted is cancelled, if the
+// driver supports it, so it should return the error that query returns.
+// A Go migration that isn't running a query at the time runs until it
+// returns.
+//
+// Register is meant to be called from init functions, and panics if a Go
+// migration with the same name is already registered.
+func Register(version int, name string, fn GoMigrationFunc) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	gm := &GoMigration{
+		Version: version,
+		Name:    fmt.Sprintf("%d_%s", version, name),
+		Func:    fn,
+	}
+	for _, other := range registry {
+		if other.Name == gm.Name {
+			panic("migrate: Register called twice for migration " + gm.Name)
+		}
+	}
+	registry = append(registry, gm)
+}
+
+// registered returns a copy of the registered Go migrations.
+func registered() []*GoMigration {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	return append([]*GoMigration(nil), registry...)
+}
+
+// migration is either a SQL migration from the source, or a registered Go
+// migration.
+type migration struct {
+	Name       string
+	Version    int
+	Path       string // empty for Go migrations
+	Repeatable bool
+
+	file *source.Migration // nil for Go migrations
+	fn   GoMigrationFunc   // nil for SQL migrations
+}
+
+// findMigrations returns the migrations in the source, along with the
+// registered Go migrations, sorted by version, followed by the repeatable
+// migrations.
+func findMigrations(src *source.Source) ([]*migration, error) {
+	files, err := src.FindMigrations()
+	if err != nil {
+		return nil, err
+	}
+	var result []*migration
+	for _, f := range files {
+		result = append(result, &migration{
+			Name:       f.Name,
+			Version:    f.Version,
+			Path:       f.Path,
+			Repeatable: f.Repeatable,
+			file:       f,
+		})
+	}
+	for _, gm := range registered() {
+		result = append(result, &migration{Name: gm.Name, Version: gm.Version, fn: gm.Func})
+	}
+	return mergeMigrations(result)
+}
+
+// mergeMigrations sorts the migrations by version, keeping repeatable
+// migrations last, and returns an error if any share a name.
+func mergeMigrations(migrations []*migration) ([]*migration, error) {
+	seen := make(map[string]bool)
+	var dups []string
+	for _, m := range migrations {
+		if seen[m.Name] {
+			dups = append(dups, m.Name)
+		}
+		seen[m.Name] = true
+	}
+	if len(dups) > 0 {
+		return nil, errors.Errorf("duplicate migration names: %s", strings.Join(dups, ", "))
+	}
+	sort.SliceStable(migrations, func(i, j int) bool {
+		if migrations[i].Repeatable || migrations[j].Repeatable {
+			return !migrations[i].Repeatable && migrations[j].Repeatable
+		}
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// directives returns the migration's directives. Go migrations have none.
+func (m *migration) directives() (*source.Directives, error) {
+	if m.file == nil {
+		return &source.Directives{}, nil
+	}
+	return m.file.ReadDirectives()
+}
+
+// statements returns the statements to execute for the migration.
+// Migrations with the no-split directive are executed as a single
+// multi-statement string, and Go migrations have no statements.
+func (m *migration) statements(directives *source.Directives) ([]string, error) {
+	switch {
+	case m.file == nil:
+		return nil, nil
+	case !directives.NoSplit:
+		return m.file.ReadStatements()
+	}
+	script, err := m.file.ReadScript()
+	if err != nil {
+		return nil, err
+	}
+	return []string{strings.TrimSpace(script)}, nil
+}
+
+// checksum returns the checksum of the migration's source file. Go
+// migrations have no checksum, so are never considered modified.
+func (m *migration) checksum() (string, error) {
+	if m.file == nil {
+		return "", nil
+	}
+	return m.file.Checksum()
+}
@@ -0,0 +1,55 @@
+package migrate
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/johngibb/migrate/db"
+)
+
+func TestMergeMigrations(t *testing.T) {
+	got, err := mergeMigrations([]*migration{
+		{Name: "1_first", Version: 1},
+		{Name: "3_third", Version: 3},
+		{Name: "2_backfill", Version: 2},
+		{Name: "4_backfill", Version: 4},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, m := range got {
+		names = append(names, m.Name)
+	}
+	if want := []string{"1_first", "2_backfill", "3_third", "4_backfill"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("got %v, want %v", names, want)
+	}
+
+	_, err = mergeMigrations([]*migration{
+		{Name: "1_first", Version: 1},
+		{Name: "1_first", Version: 1},
+	})
+	if err == nil {
+		t.Error("want error for duplicate names, got nil")
+	}
+}
+
+func TestRegister(t *testing.T) {
+	defer func(saved []*GoMigration) { registry = saved }(registry)
+	registry = nil
+
+	fn := func(ctx context.Context, d db.Driver) error { return nil }
+	Register(20200101000000, "backfill_names", fn)
+	got := registered()
+	if len(got) != 1 || got[0].Name != "20200101000000_backfill_names" || got[0].Version != 20200101000000 {
+		t.Errorf("got %+v", got)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("want panic registering a duplicate, got none")
+		}
+	}()
+	Register(20200101000000, "backfill_names", fn)
+}
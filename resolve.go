@@ -0,0 +1,82 @@
+package migrate
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/johngibb/migrate/db"
+	"github.com/johngibb/migrate/source"
+)
+
+// Resolution is how an operator resolved a failed migration.
+type Resolution int
+
+const (
+	// ResolveApplied marks the migration as applied, e.g. after its
+	// remaining statements were run by hand.
+	ResolveApplied Resolution = iota
+
+	// ResolveClear clears the failure, e.g. after its applied statements
+	// were reverted by hand, so that it is retried in full.
+	ResolveClear
+)
+
+// Resolve resolves the failed migration with the given name, once an
+// operator has manually cleaned up after it.
+func Resolve(ctx context.Context, src *source.Source, client db.Driver, name string, r Resolution) (err error) {
+	migrations, err := findMigrations(src)
+	if err != nil {
+		return errors.Wrap(err, "error reading migration files")
+	}
+	m := findByName(migrations, name)
+	if m == nil {
+		return errors.Errorf("migration not found: %s", name)
+	}
+
+	if err := lock(ctx, client, 0, &TextSink{Logger: DefaultLogger}); err != nil {
+		return err
+	}
+	defer func() {
+		_, e := client.Unlock(ctx)
+		if err == nil && e != nil {
+			err = e
+		}
+	}()
+
+	applied, err := client.GetMigrations(ctx)
+	if err != nil {
+		return errors.Wrap(err, "error fetching migrations")
+	}
+	var failed *db.Migration
+	for _, a := range applied {
+		if a.Name == name && a.Failed {
+			failed = a
+		}
+	}
+	if failed == nil {
+		return errors.Errorf("migration has not failed: %s", name)
+	}
+
+	switch r {
+	case ResolveApplied:
+		checksum, err := m.checksum()
+		if err != nil {
+			return errors.Wrap(err, "error reading migration")
+		}
+		if err := client.LogCompletedMigration(ctx, newRecord(name, checksum, time.Now(), 0)); err != nil {
+			return errors.Wrap(err, "error completing migration")
+		}
+		log.Printf("Marked %s as applied", name)
+	case ResolveClear:
+		if err := client.ClearFailedMigration(ctx, name); err != nil {
+			return errors.Wrap(err, "error clearing failed migration")
+		}
+		log.Printf("Cleared failure of %s", name)
+	default:
+		return errors.Errorf("unknown resolution: %d", r)
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+package source
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// directivePrefix introduces a directive comment, e.g.
+// "-- migrate:transaction".
+const directivePrefix = "migrate:"
+
+// Directives are options for running a migration, specified by comments
+// of the form "-- migrate:<directive>" at the top of the file, before any
+// statements.
+type Directives struct {
+	// Transaction runs the migration's statements, and records it as
+	// applied, in a single transaction. Set by "-- migrate:transaction",
+	// and cleared by "-- migrate:no-transaction", which is the default.
+	Transaction bool
+
+	// NoSplit runs the whole file as a single multi-statement string,
+	// rather than splitting it into statements first. Set by
+	// "-- migrate:no-split".
+	NoSplit bool
+
+	// Template renders the file as a text/template template, with the
+	// source's Vars as its data, before it is parsed, checksummed, or
+	// executed. Set by "-- migrate:template".
+	Template bool
+
+	// StatementTimeout and PGLockTimeout, if non-zero, override the
+	// Postgres statement_timeout and lock_timeout that Up sets before
+	// running the migration. Set by e.g. "-- migrate:statement-timeout 30s"
+	// and "-- migrate:pg-lock-timeout 2s", named like the flags of up.
+	StatementTimeout time.Duration
+	PGLockTimeout    time.Duration
+}
+
+// ReadDirectives reads the directives from the header of the migration
+// file.
+func (m *Migration) ReadDirectives() (*Directives, error) {
+	b, err := m.read()
+	if err != nil {
+		return nil, err
+	}
+	comments, err := directiveComments(b)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		d    Directives
+		seen = make(map[string]bool)
+	)
+	for _, comment := range comments {
+		name, args, ok := parseDirective(comment)
+		if !ok {
+			continue // an ordinary comment; see DirectiveWarnings
+		}
+		switch name {
+		case "transaction":
+			d.Transaction, err = true, noArgs(args)
+		case "no-transaction":
+			d.Transaction, err = false, noArgs(args)
+		case "no-split":
+			d.NoSplit, err = true, noArgs(args)
+		case "template":
+			d.Template, err = true, noArgs(args)
+		case "statement-timeout":
+			d.StatementTimeout, err = durationArg(args)
+		case "pg-lock-timeout":
+			d.PGLockTimeout, err = durationArg(args)
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "%s: invalid directive: %s", m.Name, comment)
+		}
+		seen[name] = true
+	}
+	if seen["transaction"] && seen["no-transaction"] {
+		return nil, errors.Errorf("%s: conflicting directives: migrate:transaction and migrate:no-transaction", m.Name)
+	}
+	return &d, nil
+}
+
+// DirectiveWarnings returns a message for each comment in the header of
+// the migration file that looks like a directive, but isn't one, so is
+// ignored: e.g. "-- migrate: adds users table", or a misspelled directive.
+func (m *Migration) DirectiveWarnings() ([]string, error) {
+	b, err := m.read()
+	if err != nil {
+		return nil, err
+	}
+	comments, err := directiveComments(b)
+	if err != nil {
+		return nil, err
+	}
+	var result []string
+	for _, comment := range comments {
+		if _, _, ok := parseDirective(comment); !ok {
+			result = append(result, "not a known directive, so ignored: "+comment)
+		}
+	}
+	return result, nil
+}
+
+// directiveComments returns the comments in the header of the migration
+// file that start with "migrate:", e.g. "migrate:pg-lock-timeout 2s".
+func directiveComments(b []byte) ([]string, error) {
+	var (
+		result  []string
+		scanner = bufio.NewScanner(bytes.NewReader(b))
+	)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, "--") {
+			break // end of header
+		}
+		comment := strings.TrimSpace(strings.TrimPrefix(line, "--"))
+		if strings.HasPrefix(comment, directivePrefix) {
+			result = append(result, comment)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "could not read file")
+	}
+	return result, nil
+}
+
+// directiveNames are the names of the known directives.
+var directiveNames = map[string]bool{
+	"transaction":       true,
+	"no-transaction":    true,
+	"no-split":          true,
+	"template":          true,
+	"statement-timeout": true,
+	"pg-lock-timeout":   true,
+}
+
+// parseDirective splits a directive comment into the directive's name and
+// arguments. It returns false if the comment isn't a directive, because a
+// space follows "migrate:", or the name isn't known.
+func parseDirective(comment string) (name string, args []string, ok bool) {
+	rest := strings.TrimPrefix(comment, directivePrefix)
+	if rest == "" || rest[0] == ' ' || rest[0] == '\t' {
+		return "", nil, false
+	}
+	args = strings.Fields(rest)
+	name, args = args[0], args[1:]
+	return name, args, directiveNames[name]
+}
+
+// isTemplate reports whether the migration file has the template
+// directive.
+func isTemplate(b []byte) bool {
+	comments, _ := directiveComments(b)
+	for _, comment := range comments {
+		if name, _, ok := parseDirective(comment); ok && name == "template" {
+			return true
+		}
+	}
+	return false
+}
+
+// noArgs returns an error if a directive that takes no arguments was
+// given some.
+func noArgs(args []string) error {
+	if len(args) > 0 {
+		return errors.New("unexpected argument")
+	}
+	return nil
+}
+
+// durationArg parses the single, positive duration argument of a
+// directive.
+func durationArg(args []string) (time.Duration, error) {
+	if len(args) != 1 {
+		return 0, errors.New("want one duration argument, e.g. 30s")
+	}
+	d, err := time.ParseDuration(args[0])
+	if err != nil {
+		return 0, err
+	}
+	if d <= 0 {
+		return 0, errors.New("duration must be positive")
+	}
+	return d, nil
+}
@@ -0,0 +1,106 @@
+package source
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReadDirectives(t *testing.T) {
+	tests := []struct {
+		src  string
+		want *Directives
+		err  bool
+	}{
+		{
+			src:  "create table test(id int);",
+			want: &Directives{},
+		},
+		{
+			src:  "-- migrate:transaction\ncreate table test(id int);",
+			want: &Directives{Transaction: true},
+		},
+		{
+			src:  "\n-- Adds the test table.\n--migrate:transaction\n\ncreate table test(id int);",
+			want: &Directives{Transaction: true},
+		},
+		{
+			src:  "-- migrate:no-transaction\ncreate index concurrently on test(id);",
+			want: &Directives{},
+		},
+		{
+			src:  "-- migrate:transaction\n-- migrate:no-split\ndo $$ begin perform 1; end $$;",
+			want: &Directives{Transaction: true, NoSplit: true},
+		},
+		{
+			src:  "-- migrate:template\n{{/* no variables */}}select 1;",
+			want: &Directives{Template: true},
+		},
+		{
+			// Directives after the first statement are ignored.
+			src:  "create table test(id int);\n-- migrate:transaction",
+			want: &Directives{},
+		},
+		{
+			src: "-- migrate:transaction\n-- migrate:no-transaction\nselect 1;",
+			err: true,
+		},
+		{
+			src:  "-- migrate:statement-timeout 30s\n-- migrate:pg-lock-timeout 1.5s\nalter table test add column name text;",
+			want: &Directives{StatementTimeout: 30 * time.Second, PGLockTimeout: 1500 * time.Millisecond},
+		},
+		{
+			// Unknown directives, and comments with a space after
+			// "migrate:", are ignored, and reported by Validate.
+			src:  "-- migrate:bogus\n-- migrate: adds users table\nselect 1;",
+			want: &Directives{},
+		},
+		{
+			src: "-- migrate:pg-lock-timeout\nselect 1;",
+			err: true,
+		},
+		{
+			src: "-- migrate:pg-lock-timeout soon\nselect 1;",
+			err: true,
+		},
+		{
+			src: "-- migrate:statement-timeout 0s\nselect 1;",
+			err: true,
+		},
+		{
+			src: "-- migrate:transaction please\nselect 1;",
+			err: true,
+		},
+	}
+
+	dir, err := ioutil.TempDir("", "migrate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "1_test.sql")
+
+	for i, tt := range tests {
+		if err := ioutil.WriteFile(path, []byte(tt.src), 0644); err != nil {
+			t.Fatal(err)
+		}
+		m := &Migration{Path: path, Name: "1_test", Version: 1}
+		got, err := m.ReadDirectives()
+		if tt.err {
+			if err == nil {
+				t.Errorf("%d: want error, got nil", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%d: %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%d: got %+v, want %+v", i, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package source
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Statement is a single statement parsed from a migration file.
+type Statement struct {
+	// SQL is the text of the statement, including its terminating
+	// semicolon and any comments preceding it, with surrounding
+	// whitespace trimmed.
+	SQL string
+
+	// Offset is the byte offset of the statement within the file.
+	Offset int
+
+	// Line and Column are the 1-based position of the statement within
+	// the file. Column is measured in characters.
+	Line   int
+	Column int
+}
+
+// SyntaxError reports a string, quoted identifier, or comment that isn't
+// terminated before the end of the file.
+type SyntaxError struct {
+	Msg    string
+	Line   int
+	Column int
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("%s starting at line %d, column %d", e.Msg, e.Line, e.Column)
+}
+
+// ParseStatements splits src into statements terminated by semicolons,
+// following Postgres's lexical rules, so that semicolons within comments
+// (both -- and nested /* */), string constants (including E'...' strings
+// with backslash escapes), quoted identifiers, and dollar-quoted strings
+// (with or without tags) don't end a statement.
+//
+// Text after the final semicolon is returned as a final statement, unless
+// it contains only whitespace and comments. If src ends within a string,
+// quoted identifier, or block comment, the unterminated remainder is
+// returned as the final statement, along with a *SyntaxError.
+func ParseStatements(src string) ([]*Statement, error) {
+	var (
+		result      []*Statement
+		start       int  // offset of the current statement
+		significant bool // whether the current statement has any tokens
+		pos         int
+	)
+	emit := func(end int) {
+		sql := strings.Trim(src[start:end], whitespace)
+		offset := start + strings.Index(src[start:end], sql)
+		line, col := position(src, offset)
+		result = append(result, &Statement{
+			SQL:    sql,
+			Offset: offset,
+			Line:   line,
+			Column: col,
+		})
+		start = end
+		significant = false
+	}
+	fail := func(msg string, offset int) ([]*Statement, error) {
+		emit(len(src))
+		line, col := position(src, offset)
+		return result, &SyntaxError{Msg: msg, Line: line, Column: col}
+	}
+
+	for pos < len(src) {
+		c := src[pos]
+		switch {
+		case strings.HasPrefix(src[pos:], "--"):
+			if i := strings.IndexByte(src[pos:], '\n'); i != -1 {
+				pos += i + 1
+			} else {
+				pos = len(src)
+			}
+			continue
+		case strings.HasPrefix(src[pos:], "/*"):
+			end := skipBlockComment(src, pos)
+			if end == -1 {
+				return fail("unterminated block comment", pos)
+			}
+			pos = end
+			continue
+		case strings.IndexByte(whitespace, c) != -1:
+			pos++
+			continue
+		}
+
+		significant = true
+		switch {
+		case c == '\'':
+			end := skipString(src, pos, isEscapeString(src, pos))
+			if end == -1 {
+				return fail("unterminated string", pos)
+			}
+			pos = end
+		case c == '"':
+			end := skipString(src, pos, false)
+			if end == -1 {
+				return fail("unterminated quoted identifier", pos)
+			}
+			pos = end
+		case c == '$':
+			tag := dollarTag(src, pos)
+			if tag == "" {
+				pos++
+				break
+			}
+			i := strings.Index(src[pos+len(tag):], tag)
+			if i == -1 {
+				return fail("unterminated dollar-quoted string", pos)
+			}
+			pos += len(tag) + i + len(tag)
+		case c == ';':
+			pos++
+			emit(pos)
+		default:
+			pos++
+		}
+	}
+	if significant {
+		emit(len(src))
+	}
+	return result, nil
+}
+
+const whitespace = " \t\n\r\f\v"
+
+// skipBlockComment returns the offset just past the block comment
+// starting at pos, which may contain nested block comments, or -1 if it
+// is unterminated.
+func skipBlockComment(src string, pos int) int {
+	depth := 0
+	for pos < len(src) {
+		switch {
+		case strings.HasPrefix(src[pos:], "/*"):
+			depth++
+			pos += 2
+		case strings.HasPrefix(src[pos:], "*/"):
+			depth--
+			pos += 2
+			if depth == 0 {
+				return pos
+			}
+		default:
+			pos++
+		}
+	}
+	return -1
+}
+
+// skipString returns the offset just past the string constant or quoted
+// identifier starting with the quote at pos, or -1 if it is unterminated.
+// A doubled quote is an escaped quote, as is any character preceded by a
+// backslash if backslashEscapes is set.
+func skipString(src string, pos int, backslashEscapes bool) int {
+	quote := src[pos]
+	for pos++; pos < len(src); pos++ {
+		switch src[pos] {
+		case '\\':
+			if backslashEscapes {
+				pos++
+			}
+		case quote:
+			if pos+1 < len(src) && src[pos+1] == quote {
+				pos++
+				continue
+			}
+			return pos + 1
+		}
+	}
+	return -1
+}
+
+// isEscapeString reports whether the string constant starting with the
+// quote at pos is an escape string constant, e.g. E'it\'s'.
+func isEscapeString(src string, pos int) bool {
+	if pos == 0 || (src[pos-1] != 'E' && src[pos-1] != 'e') {
+		return false
+	}
+	return pos == 1 || !isIdentChar(src[pos-2])
+}
+
+// dollarTag returns the opening tag of the dollar-quoted string starting
+// at pos, e.g. "$$" or "$body$", or "" if there isn't one (e.g. a
+// positional parameter like $1, or a $ within an identifier).
+func dollarTag(src string, pos int) string {
+	if pos > 0 && isIdentChar(src[pos-1]) {
+		return ""
+	}
+	for i := pos + 1; i < len(src); i++ {
+		c := src[i]
+		switch {
+		case c == '$':
+			return src[pos : i+1]
+		case i == pos+1 && !isIdentStart(c):
+			return ""
+		case !isIdentStart(c) && !isDigit(c):
+			return ""
+		}
+	}
+	return ""
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= utf8.RuneSelf
+}
+
+func isIdentChar(c byte) bool {
+	return isIdentStart(c) || isDigit(c) || c == '$'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// position returns the 1-based line and column of the offset in src.
+func position(src string, offset int) (line, col int) {
+	before := src[:offset]
+	line = strings.Count(before, "\n") + 1
+	col = utf8.RuneCountInString(before[strings.LastIndexByte(before, '\n')+1:]) + 1
+	return line, col
+}
@@ -0,0 +1,82 @@
+package source
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseStatementsPositions(t *testing.T) {
+	src := "-- header\ncreate table a(id int);\n\n  /* é */ insert into a values (1); select 'x;\ny';"
+	stmts, err := ParseStatements(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Statement{
+		{SQL: "-- header\ncreate table a(id int);", Offset: 0, Line: 1, Column: 1},
+		{SQL: "/* é */ insert into a values (1);", Offset: 37, Line: 4, Column: 3},
+		{SQL: "select 'x;\ny';", Offset: 72, Line: 4, Column: 37},
+	}
+	if len(stmts) != len(want) {
+		t.Fatalf("got %d statements, want %d: %s", len(stmts), len(want), toJSON(stmts))
+	}
+	for i, s := range stmts {
+		if *s != want[i] {
+			t.Errorf("statement %d: got %+v, want %+v", i, *s, want[i])
+		}
+	}
+}
+
+func TestParseStatementsUnterminated(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"select 1; select 'abc;", "unterminated string starting at line 1, column 18"},
+		{"select 1;\nselect E'abc\\';", "unterminated string starting at line 2, column 9"},
+		{`select 1; select "abc;`, "unterminated quoted identifier starting at line 1, column 18"},
+		{"select 1; select $a$ abc $$;", "unterminated dollar-quoted string starting at line 1, column 18"},
+		{"select 1; /* /* */", "unterminated block comment starting at line 1, column 11"},
+	}
+	for _, tt := range tests {
+		stmts, err := ParseStatements(tt.src)
+		var serr *SyntaxError
+		if !errors.As(err, &serr) {
+			t.Errorf("%q: got error %v, want *SyntaxError", tt.src, err)
+			continue
+		}
+		if serr.Error() != tt.want {
+			t.Errorf("%q: got error %q, want %q", tt.src, serr.Error(), tt.want)
+		}
+		// The unterminated remainder is returned as the final statement.
+		if len(stmts) != 2 {
+			t.Errorf("%q: got %d statements, want 2", tt.src, len(stmts))
+		}
+	}
+}
+
+func FuzzParseStatements(f *testing.F) {
+	for _, tt := range splitTests {
+		f.Add(tt.src)
+	}
+	f.Fuzz(func(t *testing.T, src string) {
+		stmts, _ := ParseStatements(src)
+		prev := 0
+		for i, s := range stmts {
+			if s.SQL == "" {
+				t.Fatalf("statement %d is empty", i)
+			}
+			if s.Offset < prev || !strings.HasPrefix(src[s.Offset:], s.SQL) {
+				t.Fatalf("statement %d: %q not found at offset %d", i, s.SQL, s.Offset)
+			}
+			if line, col := position(src, s.Offset); line != s.Line || col != s.Column {
+				t.Fatalf("statement %d: got line %d, column %d, want %d, %d", i, s.Line, s.Column, line, col)
+			}
+			// Nothing but whitespace separates the statements.
+			if gap := src[prev:s.Offset]; strings.TrimSpace(gap) != "" {
+				t.Fatalf("statement %d: text %q skipped", i, gap)
+			}
+			prev = s.Offset + len(s.SQL)
+		}
+	})
+}
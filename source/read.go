@@ -1,71 +1,114 @@
 package source
 
 import (
-	"bufio"
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"io"
+	"io/ioutil"
 	"os"
-	"strings"
+	"text/template"
 
 	"github.com/pkg/errors"
 )
 
-// ReadStatements reads the migration file and parses it into individual
-// statements.
-func (m *Migration) ReadStatements() ([]string, error) {
-	f, err := os.Open(m.Path)
+// open opens the migration file.
+func (m *Migration) open() (io.ReadCloser, error) {
+	if m.fsys != nil {
+		return m.fsys.Open(m.file)
+	}
+	return os.Open(m.Path)
+}
+
+// read reads the contents of the migration file, rendering it as a
+// template if it has the template directive. Other files are returned as
+// is, whatever the source's Vars.
+func (m *Migration) read() ([]byte, error) {
+	f, err := m.open()
 	if err != nil {
 		return nil, errors.Wrap(err, "could not open file")
 	}
 	defer f.Close()
-	return splitStatements(f), nil
+	b, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read file")
+	}
+	if !isTemplate(b) {
+		return b, nil
+	}
+	return render(m.Name, b, m.vars)
 }
 
-const (
-	apostrophe = "'"
-	dollarSign = "$"
-	semicolon  = ";"
-)
-
-// splitStatements splits the given file into separate statements.
-func splitStatements(r io.Reader) []string {
-	var (
-		buf      bytes.Buffer
-		inBlock  bool
-		inString bool
-		result   []string
-		scanner  = bufio.NewScanner(r)
-	)
-
-	scanner.Split(bufio.ScanBytes)
-	last := ""
-
-	for scanner.Scan() {
-		curr := scanner.Text()
-		_, _ = buf.WriteString(curr)
+// render executes the template src with the given variables, failing if
+// it references any that aren't defined, even if vars is nil.
+func render(name string, src []byte, vars map[string]string) ([]byte, error) {
+	if vars == nil {
+		vars = map[string]string{}
+	}
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(string(src))
+	if err != nil {
+		return nil, errors.Wrap(err, "could not parse template")
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, vars); err != nil {
+		return nil, errors.Wrap(err, "could not render template")
+	}
+	return buf.Bytes(), nil
+}
 
-		switch last {
-		case apostrophe:
-			inString = !inString
-		case dollarSign:
-			if curr == dollarSign && !inString {
-				inBlock = !inBlock
-			}
-		}
+// ReadStatements reads the migration file and parses it into individual
+// statements.
+func (m *Migration) ReadStatements() ([]string, error) {
+	b, err := m.read()
+	if err != nil {
+		return nil, err
+	}
+	return splitStatements(bytes.NewReader(b)), nil
+}
 
-		if curr == semicolon && !(inBlock || inString) {
-			result = append(result, buf.String())
-			buf.Reset()
-		}
+// ReadScript reads the entire migration file, for migrations that are
+// run without being split into statements.
+func (m *Migration) ReadScript() (string, error) {
+	b, err := m.read()
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
 
-		last = curr
+// ParseStatements reads the migration file and parses it into individual
+// statements, along with their positions. Unlike ReadStatements, it
+// returns a *SyntaxError if the file ends within a string, quoted
+// identifier, or block comment.
+func (m *Migration) ParseStatements() ([]*Statement, error) {
+	b, err := m.read()
+	if err != nil {
+		return nil, err
 	}
+	return ParseStatements(string(b))
+}
 
-	if buf.Len() > 0 {
-		if s := strings.TrimSpace(buf.String()); s != "" {
-			result = append(result, s)
-		}
+// Checksum returns the hex-encoded SHA-256 checksum of the migration
+// file's contents, after rendering it if it's a template.
+func (m *Migration) Checksum() (string, error) {
+	b, err := m.read()
+	if err != nil {
+		return "", err
 	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
 
+// splitStatements splits the given file into separate statements.
+func splitStatements(r io.Reader) []string {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil
+	}
+	stmts, _ := ParseStatements(string(b))
+	result := make([]string, len(stmts))
+	for i, stmt := range stmts {
+		result[i] = stmt.SQL
+	}
 	return result
 }
@@ -2,52 +2,57 @@ package source
 
 import (
 	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"reflect"
 	"strings"
 	"testing"
+	"testing/fstest"
 )
 
-func TestSplitStatements(t *testing.T) {
-	tests := []struct {
-		src        string
-		statements []string
-	}{
-		{
-			`create table test(id int)`,
-			[]string{`create table test(id int)`},
-		},
-		{
-			`
+// splitTests are the test cases for splitStatements, which also seed
+// FuzzParseStatements.
+var splitTests = []struct {
+	src        string
+	statements []string
+}{
+	{
+		`create table test(id int)`,
+		[]string{`create table test(id int)`},
+	},
+	{
+		`
 		              create table test1(id int);
 		              create table test2(id int);
 		          `,
-			[]string{
-				`create table test1(id int);`,
-				`create table test2(id int);`,
-			},
+		[]string{
+			`create table test1(id int);`,
+			`create table test2(id int);`,
 		},
-		{
-			`
+	},
+	{
+		`
 		              create table test1(
 		                  id int,
 		                  name text
 		              );
 		              create table test2(id int);
 		          `,
-			[]string{
-				`create table test1(
+		[]string{
+			`create table test1(
 		                  id int,
 		                  name text
 		              );`,
-				`create table test2(id int);`,
-			},
+			`create table test2(id int);`,
 		},
-		{
-			`insert into test select ';'`,
-			[]string{`insert into test select ';'`},
-		},
-		{
-			`create function update_trigger() returns trigger as $$
+	},
+	{
+		`insert into test select ';'`,
+		[]string{`insert into test select ';'`},
+	},
+	{
+		`create function update_trigger() returns trigger as $$
 begin
   new.tsv :=
     to_tsvector(coalesce(new.alpha, 'foo''s')) ||
@@ -55,18 +60,86 @@ begin
   return new;
 end
 $$ language plpgsql;`,
-			nil,
-		},
-		{
-			`select * from table where thing not in (';''', '');
+		nil,
+	},
+	{
+		`select * from table where thing not in (';''', '');
 select * from table where thing not in ('', '''');`,
-			[]string{
-				`select * from table where thing not in (';''', '');`,
-				`select * from table where thing not in ('', '''');`,
-			},
+		[]string{
+			`select * from table where thing not in (';''', '');`,
+			`select * from table where thing not in ('', '''');`,
 		},
-	}
-	for i, tt := range tests {
+	},
+	{
+		`select 1; -- a comment; with a semicolon
+select 2;`,
+		[]string{
+			`select 1;`,
+			`-- a comment; with a semicolon
+select 2;`,
+		},
+	},
+	{
+		`/* a block; /* nested; */ comment; */ select 1; select 2;`,
+		[]string{
+			`/* a block; /* nested; */ comment; */ select 1;`,
+			`select 2;`,
+		},
+	},
+	{
+		`create function f() returns text as $body$
+begin
+  return 'it''s; $$ fine';
+end
+$body$ language plpgsql;
+select 1;`,
+		[]string{
+			`create function f() returns text as $body$
+begin
+  return 'it''s; $$ fine';
+end
+$body$ language plpgsql;`,
+			`select 1;`,
+		},
+	},
+	{
+		`select "semi;colon" from "quoted "" table"; select 2;`,
+		[]string{
+			`select "semi;colon" from "quoted "" table";`,
+			`select 2;`,
+		},
+	},
+	{
+		`select E'it\'s; escaped', e'\\'; select 2;`,
+		[]string{
+			`select E'it\'s; escaped', e'\\';`,
+			`select 2;`,
+		},
+	},
+	{
+		`select 'not\'; select 2;`,
+		[]string{
+			`select 'not\';`,
+			`select 2;`,
+		},
+	},
+	{
+		`prepare p as select $1; select foo$bar$ from t; select 3;`,
+		[]string{
+			`prepare p as select $1;`,
+			`select foo$bar$ from t;`,
+			`select 3;`,
+		},
+	},
+	{
+		`select 1;
+-- a trailing comment`,
+		[]string{`select 1;`},
+	},
+}
+
+func TestSplitStatements(t *testing.T) {
+	for i, tt := range splitTests {
 		want := tt.statements
 		if len(want) == 0 {
 			want = []string{tt.src}
@@ -77,6 +150,94 @@ select * from table where thing not in ('', '''');`,
 	}
 }
 
+func TestChecksum(t *testing.T) {
+	dir, err := ioutil.TempDir("", "migrate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "1_test.sql")
+	if err := ioutil.WriteFile(path, []byte("create table test(id int);\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m := &Migration{Path: path, Name: "1_test", Version: 1}
+	before, err := m.Checksum()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "2e3b96fd821d1af0e2ef2e6d0160061b0d6fdcae025a9120c94c56045d14892d"; before != want {
+		t.Errorf("checksum: got %s, want %s", before, want)
+	}
+
+	// Changing the file changes the checksum.
+	if err := ioutil.WriteFile(path, []byte("create table test(id bigint);\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	after, err := m.Checksum()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before == after {
+		t.Errorf("checksum unchanged after edit: %s", after)
+	}
+}
+
+func TestTemplate(t *testing.T) {
+	fsys := fstest.MapFS{
+		"1_grant.sql": {Data: []byte("-- migrate:template\ngrant select on users to {{.role}};")},
+		"2_array.sql": {Data: []byte("select '{{1,2},{3,4}}'::int[][];")},
+	}
+	find := func(vars map[string]string) []*Migration {
+		src := NewFS(fsys)
+		src.Vars = vars
+		migrations, err := src.FindMigrations()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return migrations
+	}
+
+	// Templates are rendered, and the rendered SQL is checksummed.
+	m := find(map[string]string{"role": "reader"})[0]
+	stmts, err := m.ReadStatements()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"-- migrate:template\ngrant select on users to reader;"}; !reflect.DeepEqual(stmts, want) {
+		t.Errorf("got %q, want %q", stmts, want)
+	}
+	reader, err := m.Checksum()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer, err := find(map[string]string{"role": "writer"})[0].Checksum()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reader == writer {
+		t.Errorf("checksum unchanged by variables: %s", reader)
+	}
+
+	// Undefined variables are an error, even without any variables.
+	for _, vars := range []map[string]string{nil, {}} {
+		if _, err := find(vars)[0].ReadStatements(); err == nil || !strings.Contains(err.Error(), `map has no entry for key "role"`) {
+			t.Errorf("vars %v: got error %v, want missing key error", vars, err)
+		}
+	}
+
+	// Files without the template directive are read as is, with or
+	// without variables.
+	for _, vars := range []map[string]string{nil, {"role": "reader"}} {
+		stmts, err := find(vars)[1].ReadStatements()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []string{"select '{{1,2},{3,4}}'::int[][];"}; !reflect.DeepEqual(stmts, want) {
+			t.Errorf("vars %v: got %q, want %q", vars, stmts, want)
+		}
+	}
+}
+
 func trimAll(ss []string) []string {
 	result := make([]string, len(ss))
 	for i, s := range ss {
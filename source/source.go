@@ -4,6 +4,8 @@ package source
 
 import (
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"sort"
@@ -16,7 +18,20 @@ import (
 
 // Source is handle to a directory containing migration source files.
 type Source struct {
-	path string
+	// Recursive causes FindMigrations to search subdirectories too, e.g.
+	// when migrations are grouped into folders per year or per module.
+	// Migrations are still ordered by version across all folders.
+	Recursive bool
+
+	// Vars are the data for migration files with the template directive,
+	// which are rendered as text/template templates before they are
+	// parsed, checksummed, or executed. e.g. {{.role}} is replaced with
+	// Vars["role"]. Referencing a variable that isn't defined is an error.
+	// Other files are read as is.
+	Vars map[string]string
+
+	fsys fs.FS
+	path string // directory on disk, if created with New
 }
 
 // New creates a new Source, or returns an error if the path does not
@@ -25,12 +40,36 @@ func New(path string) (*Source, error) {
 	if _, err := os.Stat(path); os.IsNotExist(err) {
 		return nil, errors.Errorf("directory does not exist: %s", path)
 	}
-	return &Source{path: path}, nil
+	return &Source{fsys: os.DirFS(path), path: path}, nil
+}
+
+// NewFS creates a new Source that reads migrations from the root of
+// fsys, such as an embed.FS. Use fs.Sub to read them from a
+// subdirectory:
+//
+//	//go:embed migrations/*.sql
+//	var migrations embed.FS
+//
+//	sub, err := fs.Sub(migrations, "migrations")
+//	src := source.NewFS(sub)
+//
+// Create is only supported if fsys implements CreateFS.
+func NewFS(fsys fs.FS) *Source {
+	return &Source{fsys: fsys}
+}
+
+// CreateFS is a file system that new migration files can be created in.
+type CreateFS interface {
+	fs.FS
+
+	// Create creates the named file, which must not already exist.
+	Create(name string) (io.WriteCloser, error)
 }
 
 // Migration is a handle to a migration source file.
 type Migration struct {
-	// Path is the path of the file.
+	// Path is the path of the file. For sources created with NewFS, it
+	// is relative to the root of the file system.
 	Path string
 
 	// Name is the name of the migration, derived from the file name.
@@ -39,11 +78,34 @@ type Migration struct {
 	// Version is the numeric version of the migration, derived from the
 	// file name and used to sort the migrations.
 	Version int
+
+	// Repeatable is set for migrations named R__<name>.sql, which have
+	// no version, and are applied after all versioned migrations whenever
+	// their contents change, e.g. to redefine views or functions.
+	Repeatable bool
+
+	fsys fs.FS             // file system containing the file, if any
+	file string            // path of the file within fsys
+	vars map[string]string // template variables
 }
 
+// repeatablePrefix is the file name prefix of repeatable migrations.
+const repeatablePrefix = "R__"
+
 // parseMigration parses a path into a Migration.
 func parseMigration(path string) (*Migration, error) {
 	base := filepath.Base(path)
+	if strings.HasPrefix(base, repeatablePrefix) {
+		if base == repeatablePrefix+".sql" {
+			return nil, errors.Errorf("invalid file name: %s", base)
+		}
+		m := &Migration{
+			Path:       path,
+			Name:       strings.TrimSuffix(base, ".sql"),
+			Repeatable: true,
+		}
+		return m, nil
+	}
 	sep := strings.Index(base, "_")
 	if sep == -1 {
 		return nil, errors.Errorf("invalid file name: %s", base)
@@ -63,42 +125,106 @@ func parseMigration(path string) (*Migration, error) {
 
 // FindMigrations finds all migrations under the source path.
 func (s *Source) FindMigrations() ([]*Migration, error) {
-	paths, err := filepath.Glob(filepath.Join(s.path, "*.sql"))
+	files, err := s.findFiles()
 	if err != nil {
-		return nil, errors.Wrap(err, "could not glob path")
+		return nil, err
 	}
-	result := make([]*Migration, len(paths))
-	for i, p := range paths {
-		m, err := parseMigration(p)
+	result := make([]*Migration, len(files))
+	seen := make(map[string]*Migration)
+	for i, file := range files {
+		m, err := parseMigration(s.displayPath(file))
 		if err != nil {
 			return nil, err
 		}
+		if prev, ok := seen[m.Name]; ok {
+			return nil, errors.Errorf("duplicate migration name %s: %s and %s", m.Name, prev.Path, m.Path)
+		}
+		seen[m.Name] = m
+		m.fsys = s.fsys
+		m.file = file
+		m.vars = s.Vars
 		result[i] = m
 	}
 	sort.Sort(ByVersion(result))
 	return result, nil
 }
 
+// findFiles returns the paths of all .sql files in the source's file
+// system, including those in subdirectories if s.Recursive is set.
+func (s *Source) findFiles() ([]string, error) {
+	if !s.Recursive {
+		files, err := fs.Glob(s.fsys, "*.sql")
+		if err != nil {
+			return nil, errors.Wrap(err, "could not glob path")
+		}
+		return files, nil
+	}
+	var files []string
+	err := fs.WalkDir(s.fsys, ".", func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && strings.HasSuffix(file, ".sql") {
+			files = append(files, file)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "could not walk path")
+	}
+	return files, nil
+}
+
+// displayPath returns the path to report for the file within the source's
+// file system: a path on disk if there is one.
+func (s *Source) displayPath(file string) string {
+	if s.path == "" {
+		return file
+	}
+	return filepath.Join(s.path, filepath.FromSlash(file))
+}
+
 // Create generates a new migration source file under the source path.
 func (s *Source) Create(name string) (string, error) {
 	timestamp := time.Now().UTC().Format("20060102150405")
 	filename := fmt.Sprintf("%s_%s.sql", timestamp, name)
-	path := filepath.Join(s.path, filename)
-	f, err := os.Create(path)
+	if s.path != "" {
+		path := filepath.Join(s.path, filename)
+		f, err := os.Create(path)
+		if err != nil {
+			return "", err
+		}
+		if err := f.Close(); err != nil {
+			return "", err
+		}
+		return path, nil
+	}
+	fsys, ok := s.fsys.(CreateFS)
+	if !ok {
+		return "", errors.New("cannot create migrations in a read-only source")
+	}
+	f, err := fsys.Create(filename)
 	if err != nil {
 		return "", err
 	}
 	if err := f.Close(); err != nil {
 		return "", err
 	}
-	return path, nil
+	return filename, nil
 }
 
-// ByVersion sorts migrations by their version numbers.
+// ByVersion sorts migrations by their version numbers, followed by
+// repeatable migrations sorted by name.
 type ByVersion []*Migration
 
 func (ms ByVersion) Len() int      { return len(ms) }
 func (ms ByVersion) Swap(i, j int) { ms[i], ms[j] = ms[j], ms[i] }
 func (ms ByVersion) Less(i, j int) bool {
+	if ms[i].Repeatable != ms[j].Repeatable {
+		return ms[j].Repeatable
+	}
+	if ms[i].Repeatable {
+		return ms[i].Name < ms[j].Name
+	}
 	return ms[i].Version < ms[j].Version
 }
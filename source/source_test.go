@@ -1,9 +1,13 @@
 package source
 
 import (
+	"bytes"
+	"io"
 	"reflect"
 	"sort"
+	"strings"
 	"testing"
+	"testing/fstest"
 )
 
 func TestParseMigration(t *testing.T) {
@@ -17,6 +21,13 @@ func TestParseMigration(t *testing.T) {
 			Version: 123,
 			Name:    "123_add_tables_to_db",
 		},
+	}, {
+		path: "./source/R__user_views.sql",
+		want: &Migration{
+			Path:       "./source/R__user_views.sql",
+			Name:       "R__user_views",
+			Repeatable: true,
+		},
 	}}
 	for i, tt := range tests {
 		got, err := parseMigration(tt.path)
@@ -42,6 +53,19 @@ func TestSort(t *testing.T) {
 			"./source/1_first.sql",
 			"./source/10_tenth.sql",
 		},
+	}, {
+		paths: []string{
+			"./source/R__views.sql",
+			"./source/10_tenth.sql",
+			"./source/R__functions.sql",
+			"./source/1_first.sql",
+		},
+		want: []string{
+			"./source/1_first.sql",
+			"./source/10_tenth.sql",
+			"./source/R__functions.sql",
+			"./source/R__views.sql",
+		},
 	}}
 	for i, tt := range tests {
 		// Parse migrations.
@@ -68,3 +92,117 @@ func TestSort(t *testing.T) {
 		}
 	}
 }
+
+func TestFindMigrationsFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"10_tenth.sql": {Data: []byte("create table tenth(id int);")},
+		"1_first.sql":  {Data: []byte("create table first(id int);\ncreate table other(id int);")},
+		"README.md":    {Data: []byte("not a migration")},
+	}
+	src := NewFS(fsys)
+	migrations, err := src.FindMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, m := range migrations {
+		names = append(names, m.Name)
+	}
+	if want := []string{"1_first", "10_tenth"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("got %v, want %v", names, want)
+	}
+
+	// Confirm the statements are read from the file system.
+	stmts, err := migrations[0].ReadStatements()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stmts) != 2 {
+		t.Errorf("got %d statements, want 2", len(stmts))
+	}
+
+	// Confirm a read-only file system can't be written to.
+	if _, err := src.Create("add_table"); err == nil {
+		t.Error("create: want error, got nil")
+	}
+}
+
+// createFS is an in-memory CreateFS.
+type createFS struct {
+	fstest.MapFS
+}
+
+func (fsys createFS) Create(name string) (io.WriteCloser, error) {
+	f := &fstest.MapFile{}
+	fsys.MapFS[name] = f
+	return &mapFileWriter{f: f}, nil
+}
+
+type mapFileWriter struct {
+	bytes.Buffer
+	f *fstest.MapFile
+}
+
+func (w *mapFileWriter) Close() error {
+	w.f.Data = w.Bytes()
+	return nil
+}
+
+func TestCreateFS(t *testing.T) {
+	fsys := createFS{fstest.MapFS{}}
+	src := NewFS(fsys)
+	name, err := src.Create("add_table")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(name, "_add_table.sql") {
+		t.Errorf("got %s, want *_add_table.sql", name)
+	}
+	migrations, err := src.FindMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) != 1 || migrations[0].Path != name {
+		t.Errorf("got %v, want [%s]", migrations, name)
+	}
+}
+
+func TestFindMigrationsRecursive(t *testing.T) {
+	fsys := fstest.MapFS{
+		"3_third.sql":        {Data: []byte("select 3;")},
+		"2020/1_first.sql":   {Data: []byte("select 1;")},
+		"2021/4_fourth.sql":  {Data: []byte("select 4;")},
+		"users/2_second.sql": {Data: []byte("select 2;")},
+	}
+
+	// Without recursion, only the top-level migration is found.
+	src := NewFS(fsys)
+	migrations, err := src.FindMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) != 1 {
+		t.Errorf("got %d migrations, want 1", len(migrations))
+	}
+
+	// With recursion, all are found, and ordered by version.
+	src.Recursive = true
+	migrations, err = src.FindMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for _, m := range migrations {
+		paths = append(paths, m.Path)
+	}
+	want := []string{"2020/1_first.sql", "users/2_second.sql", "3_third.sql", "2021/4_fourth.sql"}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("got %v, want %v", paths, want)
+	}
+
+	// Duplicate names across folders are rejected.
+	fsys["2021/1_first.sql"] = &fstest.MapFile{Data: []byte("select 1;")}
+	if _, err := src.FindMigrations(); err == nil {
+		t.Error("want duplicate name error, got nil")
+	}
+}
@@ -0,0 +1,159 @@
+package source
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// versionLayout is the layout of the timestamp versions generated by
+// Create.
+const versionLayout = "20060102150405"
+
+// validName matches the file names of well-formed migrations, both
+// versioned and repeatable.
+var validName = regexp.MustCompile(`^([0-9]+_|R__)[A-Za-z0-9_-]+\.sql$`)
+
+// Problem is an issue with a migration file found by Validate.
+type Problem struct {
+	// Path is the path of the offending file.
+	Path string
+
+	// Message describes the problem.
+	Message string
+
+	// Warning is set if the problem doesn't prevent the migration from
+	// being run, e.g. a comment that looks like a directive, but isn't one.
+	Warning bool
+}
+
+func (p *Problem) String() string {
+	if p.Warning {
+		return fmt.Sprintf("%s: warning: %s", p.Path, p.Message)
+	}
+	return fmt.Sprintf("%s: %s", p.Path, p.Message)
+}
+
+// Validate checks every migration file in the source, returning a
+// problem for each:
+//
+//   - file whose name doesn't match the pattern <version>_<name>.sql, or
+//     R__<name>.sql for repeatable migrations
+//   - version that isn't a timestamp, as generated by Create
+//   - version or name shared with another migration
+//   - file that is empty, or contains no statements
+//   - file that ends within a string, quoted identifier, or comment,
+//     unless it has the no-split directive
+//   - file with invalid directives
+//   - comment that looks like a directive, but isn't one, as a warning
+//   - template that can't be rendered with s.Vars
+//
+// An error is returned only if the source can't be read.
+func (s *Source) Validate() ([]*Problem, error) {
+	files, err := s.findFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		problems   []*Problem
+		byVersion  = make(map[int][]*Migration)
+		byName     = make(map[string][]*Migration)
+		migrations []*Migration
+	)
+	report := func(path, format string, args ...interface{}) {
+		problems = append(problems, &Problem{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	for _, file := range files {
+		displayPath := s.displayPath(file)
+		if !validName.MatchString(path.Base(file)) {
+			report(displayPath, "file name does not match <version>_<name>.sql or R__<name>.sql")
+		}
+		m, err := parseMigration(displayPath)
+		if err != nil {
+			continue // the name has already been reported
+		}
+		m.fsys = s.fsys
+		m.file = file
+		m.vars = s.Vars
+		migrations = append(migrations, m)
+		byName[m.Name] = append(byName[m.Name], m)
+
+		if !m.Repeatable {
+			byVersion[m.Version] = append(byVersion[m.Version], m)
+			version := strings.SplitN(path.Base(file), "_", 2)[0]
+			if _, err := time.Parse(versionLayout, version); err != nil {
+				report(displayPath, "version %s is not a timestamp (YYYYMMDDhhmmss)", version)
+			}
+		}
+
+		for _, msg := range validateContents(m) {
+			report(displayPath, "%s", msg)
+		}
+		if warnings, err := m.DirectiveWarnings(); err == nil {
+			for _, msg := range warnings {
+				problems = append(problems, &Problem{Path: displayPath, Message: msg, Warning: true})
+			}
+		}
+	}
+
+	// Report duplicates in version order, once per offending file.
+	sort.Stable(ByVersion(migrations))
+	for _, m := range migrations {
+		if dups := byVersion[m.Version]; len(dups) > 1 {
+			report(m.Path, "version %d is shared with %s", m.Version, otherPaths(dups, m))
+		}
+		if dups := byName[m.Name]; len(dups) > 1 {
+			report(m.Path, "name %s is shared with %s", m.Name, otherPaths(dups, m))
+		}
+	}
+	return problems, nil
+}
+
+// validateContents returns a message for each problem with the contents
+// of the migration file.
+func validateContents(m *Migration) []string {
+	b, err := m.read()
+	if err != nil {
+		return []string{err.Error()}
+	}
+	if len(bytes.TrimSpace(b)) == 0 {
+		return []string{"file is empty"}
+	}
+
+	var result []string
+	d, directivesErr := m.ReadDirectives()
+
+	// Files that aren't split into statements are sent to the database
+	// as is, so there's nothing more to check.
+	if d == nil || !d.NoSplit {
+		stmts, err := ParseStatements(string(b))
+		if err != nil {
+			result = append(result, err.Error())
+		}
+		if len(stmts) == 0 {
+			result = append(result, "file contains no statements")
+		}
+	}
+	if directivesErr != nil {
+		result = append(result, directivesErr.Error())
+	}
+	return result
+}
+
+// otherPaths returns the comma-separated paths of the migrations other
+// than m.
+func otherPaths(mm []*Migration, m *Migration) string {
+	var paths []string
+	for _, other := range mm {
+		if other != m {
+			paths = append(paths, other.Path)
+		}
+	}
+	return strings.Join(paths, ", ")
+}
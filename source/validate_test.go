@@ -0,0 +1,77 @@
+package source
+
+import (
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+func TestValidate(t *testing.T) {
+	fsys := fstest.MapFS{
+		"20200101000000_ok.sql":         {Data: []byte("create table ok(id int);")},
+		"20200102000000_empty.sql":      {Data: []byte("\n  \n")},
+		"20200103000000_comments.sql":   {Data: []byte("-- nothing to see here\n")},
+		"20200104000000_string.sql":     {Data: []byte("insert into t values ('oops);")},
+		"20200105000000_dollar.sql":     {Data: []byte("create function f() returns int as $$ select 1;")},
+		"20200105000001_no_split.sql":   {Data: []byte("-- migrate:no-split\ninsert into t values ('oops);")},
+		"20200106000000_directive.sql":  {Data: []byte("-- migrate:bogus\nselect 1;")},
+		"20200106000001_comment.sql":    {Data: []byte("-- migrate: adds users table\nselect 1;")},
+		"20200106000002_invalid.sql":    {Data: []byte("-- migrate:transaction please\nselect 1;")},
+		"20200107000000_dup.sql":        {Data: []byte("select 1;")},
+		"20200107000000_dup_again.sql":  {Data: []byte("select 1;")},
+		"7_small.sql":                   {Data: []byte("select 1;")},
+		"add_users.sql":                 {Data: []byte("select 1;")},
+		"20200108000000_bad name!.sql":  {Data: []byte("select 1;")},
+		"R__views.sql":                  {Data: []byte("create or replace view v as select 1;")},
+		"R__bad name.sql":               {Data: []byte("select 1;")},
+		"README.md":                     {Data: []byte("not a migration")},
+		"nested/20200101000000_ok.sql":  {Data: []byte("select 1;")},
+		"nested/20200109000000_sub.sql": {Data: []byte("select 1;")},
+	}
+	src := NewFS(fsys)
+	src.Recursive = true
+	problems, err := src.Validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, p := range problems {
+		got = append(got, p.String())
+	}
+	want := []string{
+		"20200102000000_empty.sql: file is empty",
+		"20200103000000_comments.sql: file contains no statements",
+		"20200104000000_string.sql: unterminated string starting at line 1, column 23",
+		"20200105000000_dollar.sql: unterminated dollar-quoted string starting at line 1, column 36",
+		"20200106000000_directive.sql: warning: not a known directive, so ignored: migrate:bogus",
+		"20200106000001_comment.sql: warning: not a known directive, so ignored: migrate: adds users table",
+		"20200106000002_invalid.sql: 20200106000002_invalid: invalid directive: migrate:transaction please: unexpected argument",
+		"20200108000000_bad name!.sql: file name does not match <version>_<name>.sql or R__<name>.sql",
+		"7_small.sql: version 7 is not a timestamp (YYYYMMDDhhmmss)",
+		"R__bad name.sql: file name does not match <version>_<name>.sql or R__<name>.sql",
+		"add_users.sql: file name does not match <version>_<name>.sql or R__<name>.sql",
+		"20200101000000_ok.sql: version 20200101000000 is shared with nested/20200101000000_ok.sql",
+		"20200101000000_ok.sql: name 20200101000000_ok is shared with nested/20200101000000_ok.sql",
+		"nested/20200101000000_ok.sql: version 20200101000000 is shared with 20200101000000_ok.sql",
+		"nested/20200101000000_ok.sql: name 20200101000000_ok is shared with 20200101000000_ok.sql",
+		"20200107000000_dup.sql: version 20200107000000 is shared with 20200107000000_dup_again.sql",
+		"20200107000000_dup_again.sql: version 20200107000000 is shared with 20200107000000_dup.sql",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got:\n%s\nwant:\n%s", toJSON(got), toJSON(want))
+	}
+}
+
+func TestValidateClean(t *testing.T) {
+	fsys := fstest.MapFS{
+		"20200101000000_first.sql":  {Data: []byte("-- migrate:transaction\ncreate table first(id int);")},
+		"20200102000000_second.sql": {Data: []byte("create table second(id int);")},
+	}
+	problems, err := NewFS(fsys).Validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 0 {
+		t.Errorf("want no problems, got %v", problems)
+	}
+}
@@ -0,0 +1,66 @@
+package migrate
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/johngibb/migrate/db"
+	"github.com/johngibb/migrate/db/sqlite"
+	"github.com/johngibb/migrate/source"
+)
+
+// TestUpSQLite runs migrations end to end against SQLite, which, unlike
+// the Postgres integration tests, needs no server.
+func TestUpSQLite(t *testing.T) {
+	ctx := context.Background()
+	client, err := sqlite.Open(ctx, "sqlite://"+filepath.Join(t.TempDir(), "test.db"), db.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close(ctx)
+
+	fsys := fstest.MapFS{
+		"1_add_users_table.sql": {Data: []byte(`create table users(id int, name text);`)},
+		"2_add_alice.sql":       {Data: []byte(`insert into users values (1, 'alice'); insert into users values (2, 'bob');`)},
+	}
+	if err := Up(ctx, source.NewFS(fsys), client, true); err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	if err := client.DB().QueryRowContext(ctx, `select count(*) from users;`).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("got %d users, want 2", n)
+	}
+
+	// A failed migration is rolled back, and recorded as failed.
+	fsys["3_add_carol.sql"] = &fstest.MapFile{Data: []byte("-- migrate:transaction\n" +
+		`insert into users values (3, 'carol'); insert into nope values (1);`)}
+	if err := Up(ctx, source.NewFS(fsys), client, true); err == nil {
+		t.Fatal("want error, got nil")
+	}
+	if err := client.DB().QueryRowContext(ctx, `select count(*) from users;`).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("got %d users after failure, want 2", n)
+	}
+	status, err := GetStatus(ctx, source.NewFS(fsys), client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var states []State
+	for _, e := range status {
+		states = append(states, e.State)
+	}
+	want := []State{StateApplied, StateApplied, StateFailed}
+	if len(states) != len(want) || states[0] != want[0] || states[1] != want[1] || states[2] != want[2] {
+		t.Errorf("got states %v, want %v", states, want)
+	}
+	if ok, err := client.TryLock(ctx); err != nil || !ok {
+		t.Errorf("lock was not released: %v, %v", ok, err)
+	}
+}
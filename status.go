@@ -2,48 +2,225 @@ package migrate
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"log"
 	"strconv"
+	"time"
 
 	"github.com/johngibb/migrate/db"
 	"github.com/johngibb/migrate/source"
 )
 
-// Status displays every migration, and whether it's been applied yet.
-func Status(ctx context.Context, src *source.Source, db *db.Client) error {
-	migrations, err := src.FindMigrations()
+// State is the state of a migration in the database.
+type State string
+
+const (
+	// StatePending means the migration has not been applied, or, for
+	// repeatable migrations, has changed since it was last applied.
+	StatePending State = "pending"
+
+	// StateApplied means the migration has been applied.
+	StateApplied State = "applied"
+
+	// StateModified means the migration has been applied, but its source
+	// has been edited since.
+	StateModified State = "modified"
+
+	// StateFailed means the migration failed partway through.
+	StateFailed State = "failed"
+)
+
+// StatusEntry describes a migration, and whether it's been applied yet.
+type StatusEntry struct {
+	Name       string `json:"name"`
+	Version    int    `json:"version"`
+	Path       string `json:"path"`
+	State      State  `json:"state"`
+	Repeatable bool   `json:"repeatable,omitempty"`
+
+	// The remaining fields are copied from the migration's record in the
+	// database, and are zero for pending migrations, as well as for
+	// migrations recorded by older versions. For repeatable migrations,
+	// they describe when it was last applied, and the checksum it had.
+	AppliedAt   *time.Time    `json:"applied_at,omitempty"`
+	Duration    time.Duration `json:"-"` // encoded as duration_ms
+	Checksum    string        `json:"checksum,omitempty"`
+	ToolVersion string        `json:"tool_version,omitempty"`
+	AppliedBy   string        `json:"applied_by,omitempty"`
+	Hostname    string        `json:"hostname,omitempty"`
+
+	// Baselined is set if the migration was recorded as applied by
+	// Baseline, without being executed.
+	Baselined bool `json:"baselined,omitempty"`
+
+	// Reason is why an operator marked the migration as applied by hand,
+	// if they did.
+	Reason string `json:"reason,omitempty"`
+
+	// FailedStatement is the zero-based index of the statement that
+	// failed, if State is StateFailed.
+	FailedStatement *int   `json:"failed_statement,omitempty"`
+	Error           string `json:"error,omitempty"`
+}
+
+// MarshalJSON encodes the entry, with its duration in milliseconds.
+func (e *StatusEntry) MarshalJSON() ([]byte, error) {
+	type entry StatusEntry // avoid recursing into MarshalJSON
+	return json.Marshal(struct {
+		*entry
+		DurationMS int64 `json:"duration_ms,omitempty"`
+	}{(*entry)(e), e.Duration.Milliseconds()})
+}
+
+// GetStatus returns every migration, and whether it's been applied yet.
+func GetStatus(ctx context.Context, src *source.Source, client db.Driver) ([]*StatusEntry, error) {
+	migrations, err := findMigrations(src)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	applied, err := db.GetMigrations(ctx)
+	applied, err := client.GetMigrations(ctx)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	isApplied := func(name string) bool {
+	findApplied := func(name string) *db.Migration {
+		var result *db.Migration
 		for _, a := range applied {
-			if a.Name == name {
+			// A repeatable migration may have been applied, and then
+			// failed to be applied again since; report the failure.
+			if a.Name == name && (result == nil || a.Failed) {
+				result = a
+			}
+		}
+		return result
+	}
+
+	modified, err := findModified(migrations, applied)
+	if err != nil {
+		return nil, err
+	}
+	isModified := func(name string) bool {
+		for _, mod := range modified {
+			if mod.migration.Name == name {
 				return true
 			}
 		}
 		return false
 	}
 
-	w := maxNameWidth(migrations)
-	for _, m := range migrations {
-		status := "pending"
-		if isApplied(m.Name) {
-			status = "applied"
+	result := make([]*StatusEntry, len(migrations))
+	for i, m := range migrations {
+		e := &StatusEntry{
+			Name:       m.Name,
+			Version:    m.Version,
+			Path:       m.Path,
+			State:      StatePending,
+			Repeatable: m.Repeatable,
 		}
+		if a := findApplied(m.Name); a != nil {
+			changed := false
+			if m.Repeatable && !a.Failed {
+				checksum, err := m.checksum()
+				if err != nil {
+					return nil, err
+				}
+				changed = checksum != a.Checksum
+			}
+			switch {
+			case a.Failed:
+				e.State = StateFailed
+				e.FailedStatement = &a.FailedStatement
+				e.Error = a.Error
+			case isModified(m.Name):
+				e.State = StateModified
+			case changed:
+				e.State = StatePending
+			default:
+				e.State = StateApplied
+			}
+			if !a.AppliedAt.IsZero() {
+				e.AppliedAt = &a.AppliedAt
+			}
+			e.Duration = a.Duration
+			e.Checksum = a.Checksum
+			e.ToolVersion = a.ToolVersion
+			e.AppliedBy = a.AppliedBy
+			e.Hostname = a.Hostname
+			e.Baselined = a.Baselined
+			e.Reason = a.Reason
+		}
+		result[i] = e
+	}
+	return result, nil
+}
 
-		log.Printf("%-"+strconv.Itoa(w)+"s %s\n", m.Name, status)
+// Status displays every migration, and whether it's been applied yet.
+func Status(ctx context.Context, src *source.Source, client db.Driver) error {
+	entries, err := GetStatus(ctx, src, client)
+	if err != nil {
+		return err
 	}
+	PrintStatus(entries)
 	return nil
 }
 
-func maxNameWidth(mm []*source.Migration) int {
+// PrintStatus displays the entries returned by GetStatus.
+func PrintStatus(entries []*StatusEntry) {
+	w := maxNameWidth(entries)
+	for _, e := range entries {
+		log.Printf("%-"+strconv.Itoa(w)+"s %s\n", e.Name, describeState(e))
+	}
+}
+
+// describeState summarizes the state of the migration, along with when,
+// how quickly, and by whom it was applied, if known. For repeatable
+// migrations, it includes the checksum they were last applied with.
+func describeState(e *StatusEntry) string {
+	s := string(e.State)
+	switch {
+	case e.State == StateFailed:
+		return fmt.Sprintf("failed at statement %d: %s", *e.FailedStatement+1, e.Error)
+	case e.AppliedAt == nil: // pending, or recorded by an older version
+		return s
+	case e.State == StatePending:
+		s += ", last applied"
+	}
+	// Baselined and marked migrations weren't executed, so took no time.
+	switch {
+	case e.Baselined:
+		s += fmt.Sprintf(" (baselined) %s", e.AppliedAt.Local().Format(time.RFC3339))
+	case e.Reason != "":
+		s += fmt.Sprintf(" (marked) %s", e.AppliedAt.Local().Format(time.RFC3339))
+	default:
+		s += fmt.Sprintf(" %s (%v)", e.AppliedAt.Local().Format(time.RFC3339), e.Duration)
+	}
+	if e.AppliedBy != "" || e.Hostname != "" {
+		s += fmt.Sprintf(" by %s@%s", e.AppliedBy, e.Hostname)
+	}
+	if e.Repeatable && e.Checksum != "" {
+		s += fmt.Sprintf(", checksum %.12s", e.Checksum)
+	}
+	if e.Reason != "" {
+		s += fmt.Sprintf(": %s", e.Reason)
+	}
+	return s
+}
+
+// HasPending reports whether any of the migrations have yet to be
+// successfully applied.
+func HasPending(entries []*StatusEntry) bool {
+	for _, e := range entries {
+		if e.State == StatePending || e.State == StateFailed {
+			return true
+		}
+	}
+	return false
+}
+
+func maxNameWidth(entries []*StatusEntry) int {
 	w := 0
-	for _, m := range mm {
-		if n := len(m.Name); n > w {
+	for _, e := range entries {
+		if n := len(e.Name); n > w {
 			w = n
 		}
 	}
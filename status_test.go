@@ -0,0 +1,124 @@
+package migrate
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestStatusEntryJSON(t *testing.T) {
+	appliedAt := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	failedStatement := 1
+	tests := []struct {
+		entry *StatusEntry
+		want  string
+	}{
+		{
+			entry: &StatusEntry{Name: "1_first", Version: 1, Path: "migrations/1_first.sql", State: StatePending},
+			want:  `{"name":"1_first","version":1,"path":"migrations/1_first.sql","state":"pending"}`,
+		},
+		{
+			entry: &StatusEntry{
+				Name:        "2_second",
+				Version:     2,
+				Path:        "migrations/2_second.sql",
+				State:       StateApplied,
+				AppliedAt:   &appliedAt,
+				Duration:    1500 * time.Millisecond,
+				Checksum:    "abc",
+				ToolVersion: "v1.0.0",
+				AppliedBy:   "deploy",
+				Hostname:    "ci",
+			},
+			want: `{"name":"2_second","version":2,"path":"migrations/2_second.sql","state":"applied",` +
+				`"applied_at":"2020-01-02T03:04:05Z","checksum":"abc","tool_version":"v1.0.0",` +
+				`"applied_by":"deploy","hostname":"ci","duration_ms":1500}`,
+		},
+		{
+			entry: &StatusEntry{
+				Name:            "3_third",
+				Version:         3,
+				Path:            "migrations/3_third.sql",
+				State:           StateFailed,
+				FailedStatement: &failedStatement,
+				Error:           "boom",
+			},
+			want: `{"name":"3_third","version":3,"path":"migrations/3_third.sql","state":"failed",` +
+				`"failed_statement":1,"error":"boom"}`,
+		},
+	}
+	for _, tt := range tests {
+		got, err := json.Marshal(tt.entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s:\ngot  %s\nwant %s", tt.entry.Name, got, tt.want)
+		}
+	}
+}
+
+func TestHasPending(t *testing.T) {
+	tests := []struct {
+		states []State
+		want   bool
+	}{
+		{nil, false},
+		{[]State{StateApplied, StateModified}, false},
+		{[]State{StateApplied, StatePending}, true},
+		{[]State{StateFailed}, true},
+	}
+	for _, tt := range tests {
+		var entries []*StatusEntry
+		for _, s := range tt.states {
+			entries = append(entries, &StatusEntry{State: s})
+		}
+		if got := HasPending(entries); got != tt.want {
+			t.Errorf("%v: got %v, want %v", tt.states, got, tt.want)
+		}
+	}
+}
+
+func TestDescribeState(t *testing.T) {
+	appliedAt := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	applied := appliedAt.Local().Format(time.RFC3339)
+	failedStatement := 1
+	tests := []struct {
+		entry *StatusEntry
+		want  string
+	}{
+		{
+			entry: &StatusEntry{State: StatePending},
+			want:  "pending",
+		},
+		{
+			entry: &StatusEntry{State: StateApplied, AppliedAt: &appliedAt, Duration: time.Second, AppliedBy: "deploy", Hostname: "ci"},
+			want:  "applied " + applied + " (1s) by deploy@ci",
+		},
+		{
+			entry: &StatusEntry{State: StateApplied, AppliedAt: &appliedAt, Baselined: true, AppliedBy: "deploy", Hostname: "ci"},
+			want:  "applied (baselined) " + applied + " by deploy@ci",
+		},
+		{
+			entry: &StatusEntry{State: StateApplied, AppliedAt: &appliedAt, Reason: "hotfix #123", AppliedBy: "ops", Hostname: "bastion"},
+			want:  "applied (marked) " + applied + " by ops@bastion: hotfix #123",
+		},
+		{
+			entry: &StatusEntry{State: StateFailed, FailedStatement: &failedStatement, Error: "boom"},
+			want:  "failed at statement 2: boom",
+		},
+		{
+			entry: &StatusEntry{State: StateApplied, Repeatable: true, AppliedAt: &appliedAt, Duration: time.Second, Checksum: "2e3b96fd821d1af0e2ef"},
+			want:  "applied " + applied + " (1s), checksum 2e3b96fd821d",
+		},
+		{
+			entry: &StatusEntry{State: StatePending, Repeatable: true, AppliedAt: &appliedAt, Duration: time.Second, Checksum: "2e3b96fd821d1af0e2ef"},
+			want:  "pending, last applied " + applied + " (1s), checksum 2e3b96fd821d",
+		},
+	}
+	for _, tt := range tests {
+		if got := describeState(tt.entry); got != tt.want {
+			t.Errorf("got %q, want %q", got, tt.want)
+		}
+	}
+}
@@ -2,8 +2,10 @@ package migrate
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -15,93 +17,503 @@ import (
 
 var DefaultLogger = log.New(os.Stderr, "", 0)
 
+// UpOptions configures how pending migrations are applied.
+type UpOptions struct {
+	// Quiet suppresses all output unless an error occurs.
+	Quiet bool
+
+	// AllowModified permits running even though previously applied
+	// migrations have been edited since, and re-stamps their recorded
+	// checksums to match the current source.
+	AllowModified bool
+
+	// LockTimeout is how long to wait for another process to release
+	// the migration lock, like the -lock-timeout flag of up. If zero, Up
+	// fails immediately if the lock is held.
+	LockTimeout time.Duration
+
+	// StatementTimeout and PGLockTimeout, if non-zero, are the Postgres
+	// statement_timeout and lock_timeout to set before running each
+	// migration, unless the migration's statement-timeout or
+	// pg-lock-timeout directives override them. PGLockTimeout
+	// bounds how long DDL waits for table locks, so that it can't stall
+	// other traffic queued behind it; it is unrelated to LockTimeout.
+	StatementTimeout time.Duration
+	PGLockTimeout    time.Duration
+
+	// LockRetries is how many times to retry a statement that fails
+	// because it exceeded the lock timeout. The first retry waits
+	// LockRetryDelay, or one second if zero, and each one after that
+	// waits twice as long. A migration in a transaction is rolled back,
+	// and retried in full.
+	LockRetries    int
+	LockRetryDelay time.Duration
+
+	// To, if set, is the version or name of the last migration to apply.
+	// Pending migrations that come after it, including repeatable
+	// migrations, are left pending.
+	To string
+
+	// Events receives progress as migrations are applied. If nil, it is
+	// logged as text to DefaultLogger.
+	Events EventSink
+}
+
+// events returns the sink events should be sent to.
+func (opts *UpOptions) events() EventSink {
+	if opts.Events == nil {
+		return &TextSink{Logger: DefaultLogger}
+	}
+	return opts.Events
+}
+
 // Up applies all pending migrations from src to the db.
-func Up(ctx context.Context, src *source.Source, db *db.Client, quiet bool) (err error) {
-	logger := DefaultLogger
+func Up(ctx context.Context, src *source.Source, client db.Driver, quiet bool) error {
+	return UpWithOptions(ctx, src, client, UpOptions{Quiet: quiet})
+}
 
-	// If we're running in quiet mode, buffer all log messages, and only print
+// UpWithOptions applies all pending migrations from src to the db, as
+// configured by opts.
+func UpWithOptions(ctx context.Context, src *source.Source, client db.Driver, opts UpOptions) (err error) {
+	sink := opts.events()
+
+	// If we're running in quiet mode, buffer all events, and only send
 	// them if an error occurs.
-	if quiet {
-		var buf strings.Builder
-		logger = log.New(&buf, "", 0)
-		defer func() {
-			if quiet && err != nil {
-				DefaultLogger.Print(buf.String())
+	if opts.Quiet {
+		buf := &bufferedSink{}
+		defer func(sink EventSink) {
+			if err != nil {
+				buf.flush(sink)
 			}
-		}()
+		}(sink)
+		sink = buf
 	}
 
-	migrations, err := src.FindMigrations()
+	migrations, err := findMigrations(src)
 	if err != nil {
 		return errors.Wrap(err, "error reading migration files")
 	}
 
-	// Acquire an exclusive lock.
-	locked, err := db.TryLock(ctx)
-	if err != nil {
-		return errors.Wrap(err, "error acquiring lock")
-	}
-	if !locked {
-		return errors.New("could not acquire lock")
+	// Acquire an exclusive lock, waiting for it if requested.
+	if err := lock(ctx, client, opts.LockTimeout, sink); err != nil {
+		return err
 	}
 
+	// From here on, run queries with a context that can't be cancelled,
+	// so that if ctx is, the outcome is still recorded, and the lock
+	// released. The running migration statement is cancelled instead, and
+	// nothing after it is started.
+	run := uncancelled{ctx}
+	runner := runStatements(ctx, client)
+	defer runner.stop()
+
 	// Release the lock after running all migrations.
 	defer func() {
-		_, e := db.Unlock(ctx)
-		if err != nil && e != nil {
+		_, e := client.Unlock(run)
+		if err == nil && e != nil {
 			err = e
 		}
+		if e == nil {
+			emit(sink, &Event{Type: EventLockReleased})
+		}
 	}()
 
-	applied, err := db.GetMigrations(ctx)
+	pending, modified, err := findPending(run, migrations, client, opts)
 	if err != nil {
-		return errors.Wrap(err, "error fetching migrations")
+		return err
 	}
-	isApplied := func(name string) bool {
-		for _, a := range applied {
-			if a.Name == name {
-				return true
-			}
+
+	// Re-stamp the checksums of modified migrations, which findPending
+	// only allows if requested.
+	for _, mod := range modified {
+		if err := client.UpdateChecksum(run, mod.migration.Name, mod.checksum); err != nil {
+			return errors.Wrap(err, "error updating checksum")
 		}
-		return false
+		emit(sink, &Event{Type: EventChecksumUpdated, Migration: mod.migration.Name})
 	}
 
-	var pending []*source.Migration
-	for _, m := range migrations {
-		if !isApplied(m.Name) {
-			pending = append(pending, m)
+	if len(pending) == 0 {
+		emit(sink, &Event{Type: EventNothingToDo})
+		return nil
+	}
+
+	// Restore the session's default timeouts afterwards, in case the
+	// connection is used for anything else.
+	var current timeouts
+	defer func() {
+		if e := setTimeouts(run, client, &current, timeouts{}); e != nil {
+			emit(sink, &Event{Type: EventWarning, Message: fmt.Sprintf("error resetting timeouts: %v", e)})
+		}
+	}()
+
+	for i, m := range pending {
+		if ctx.Err() != nil {
+			return interrupted(ctx, pending, i, false)
+		}
+		if err := apply(ctx, client, runner, m, opts, &current, sink); err != nil {
+			if ctx.Err() != nil {
+				return interrupted(ctx, pending, i, true)
+			}
+			return err
 		}
 	}
+	return nil
+}
 
-	if len(pending) == 0 {
-		logger.Println("nothing to do")
+// defaultLockRetryDelay is how long to wait before the first retry after
+// a lock timeout, unless otherwise specified.
+const defaultLockRetryDelay = time.Second
+
+// timeouts are the statement and lock timeouts set on the session, where
+// zero means the session's default.
+type timeouts struct {
+	statement, lock time.Duration
+}
+
+// setTimeouts sets the session's timeouts to want, unless current says
+// they already are.
+func setTimeouts(ctx context.Context, client db.Driver, current *timeouts, want timeouts) error {
+	if *current == want {
 		return nil
 	}
+	setter, ok := client.(db.TimeoutSetter)
+	if !ok {
+		return errors.New("statement and lock timeouts are not supported by this database")
+	}
+	if err := setter.SetTimeouts(ctx, want.statement, want.lock); err != nil {
+		return err
+	}
+	*current = want
+	return nil
+}
+
+// apply executes the migration's statements, and records it as applied.
+// If the migration has the transaction directive, both are done in a
+// single transaction. If it has the no-split directive, the whole file is
+// executed, and reported, as a single statement. Go migrations are applied
+// by calling their function instead.
+//
+// The statement and lock timeouts are set first, as configured by opts
+// and the migration's directives, updating current. Statements that
+// exceed the lock timeout are retried as configured by opts. If ctx is
+// cancelled, the running statement, or the query a Go migration is
+// running, is cancelled by runner, or else the migration stops before its
+// next statement, and is recorded as failed.
+func apply(ctx context.Context, client db.Driver, runner *statementRunner, m *migration, opts UpOptions, current *timeouts, sink EventSink) (err error) {
+	run := uncancelled{ctx} // see UpWithOptions
+	directives, err := m.directives()
+	if err != nil {
+		return errors.Wrap(err, "error reading migration")
+	}
+	stmts, err := m.statements(directives)
+	if err != nil {
+		return errors.Wrap(err, "error reading migration")
+	}
+	checksum, err := m.checksum()
+	if err != nil {
+		return errors.Wrap(err, "error reading migration")
+	}
+	want := timeouts{statement: opts.StatementTimeout, lock: opts.PGLockTimeout}
+	if directives.StatementTimeout > 0 {
+		want.statement = directives.StatementTimeout
+	}
+	if directives.PGLockTimeout > 0 {
+		want.lock = directives.PGLockTimeout
+	}
+	if err := setTimeouts(run, client, current, want); err != nil {
+		return errors.Wrap(err, "error setting timeouts")
+	}
 
-	for _, m := range pending {
-		logger.Printf("Running %s:", m.Name)
-		stmts, err := m.ReadStatements()
+	emit(sink, &Event{Type: EventMigrationStarted, Migration: m.Name, Transaction: directives.Transaction})
+	appliedAt := time.Now()
+	defer func() {
+		e := &Event{
+			Type:        EventMigrationFinished,
+			Migration:   m.Name,
+			Transaction: directives.Transaction,
+			Duration:    time.Since(appliedAt),
+		}
 		if err != nil {
-			return errors.Wrap(err, "error reading migration")
+			e.Error = err.Error()
 		}
-		for _, stmt := range stmts {
-			logger.Println(prefixAll("> ", stmt))
-			start := time.Now()
-			err := db.Exec(ctx, stmt)
-			elapsed := time.Since(start)
-			if err != nil {
-				logger.Printf("=> FAIL (%s)", elapsed)
+		emit(sink, e)
+	}()
+
+	inTx := false
+	begin := func() error {
+		if !directives.Transaction {
+			return nil
+		}
+		if err := client.Exec(run, "begin;"); err != nil {
+			return errors.Wrap(err, "error beginning transaction")
+		}
+		inTx = true
+		return nil
+	}
+	if err := begin(); err != nil {
+		return err
+	}
+	rollback := func() {
+		if !inTx {
+			return
+		}
+		inTx = false
+		if err := client.Exec(run, "rollback;"); err != nil {
+			emit(sink, &Event{
+				Type:      EventWarning,
+				Migration: m.Name,
+				Message:   fmt.Sprintf("error rolling back transaction: %v", err),
+			})
+		}
+	}
+
+	// fail rolls back, and records the failure of the i'th statement, so
+	// that a partially applied migration isn't blindly retried.
+	fail := func(i int, err error) error {
+		rollback()
+		record := newRecord(m.Name, checksum, appliedAt, time.Since(appliedAt))
+		record.Failed = true
+		record.FailedStatement = i
+		record.Error = err.Error()
+		if e := client.LogFailedMigration(run, record); e != nil {
+			emit(sink, &Event{
+				Type:      EventWarning,
+				Migration: m.Name,
+				Message:   fmt.Sprintf("error recording failed migration: %v", e),
+			})
+		}
+		return err
+	}
+
+	// retry rolls back, and waits to retry, if err is a lock timeout and
+	// any retries remain.
+	retries := 0
+	retry := func(err error) bool {
+		if retries >= opts.LockRetries || !db.IsLockTimeout(err) {
+			return false
+		}
+		rollback()
+		delay := opts.LockRetryDelay
+		if delay == 0 {
+			delay = defaultLockRetryDelay
+		}
+		delay <<= retries
+		retries++
+		emit(sink, &Event{
+			Type:      EventWarning,
+			Migration: m.Name,
+			Message:   fmt.Sprintf("lock timeout; retrying in %v (%d of %d)", delay, retries, opts.LockRetries),
+		})
+		select {
+		case <-time.After(delay):
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	if m.fn != nil {
+		err := runner.call(func(ctx context.Context) error {
+			return m.fn(ctx, client)
+		})
+		if err != nil {
+			return fail(0, err)
+		}
+	}
+	for i := 0; i < len(stmts); i++ {
+		stmt := stmts[i]
+		if err := ctx.Err(); err != nil {
+			return fail(i, err)
+		}
+		emit(sink, &Event{
+			Type:           EventStatementStarted,
+			Migration:      m.Name,
+			Statement:      stmt,
+			StatementIndex: i,
+		})
+		start := time.Now()
+		err := runner.exec(stmt)
+		finished := &Event{
+			Type:           EventStatementFinished,
+			Migration:      m.Name,
+			Statement:      stmt,
+			StatementIndex: i,
+			Duration:       time.Since(start),
+		}
+		if err != nil {
+			finished.Error = err.Error()
+			emit(sink, finished)
+			if !retry(err) {
+				return fail(i, err)
+			}
+			if !directives.Transaction {
+				i-- // retry just the failed statement
+				continue
+			}
+			if err := begin(); err != nil {
 				return err
 			}
-			logger.Printf("=> OK (%v)", elapsed)
+			i = -1 // retry the whole transaction
+			continue
 		}
-		if err := db.LogCompletedMigration(ctx, m.Name); err != nil {
-			return errors.Wrap(err, "error completing migration")
+		emit(sink, finished)
+	}
+	record := newRecord(m.Name, checksum, appliedAt, time.Since(appliedAt))
+	if err := client.LogCompletedMigration(run, record); err != nil {
+		rollback()
+		return errors.Wrap(err, "error completing migration")
+	}
+
+	if directives.Transaction {
+		if err := client.Exec(run, "commit;"); err != nil {
+			rollback()
+			return errors.Wrap(err, "error committing transaction")
+		}
+	}
+	return nil
+}
+
+// lock acquires the exclusive migration lock, waiting up to timeout for
+// another process to release it.
+func lock(ctx context.Context, client db.Driver, timeout time.Duration, sink EventSink) error {
+	locked, err := db.Lock(ctx, client, timeout, func(h *db.LockHolder) {
+		emit(sink, &Event{Type: EventLockWaiting, Message: h.String()})
+	})
+	if err != nil {
+		return errors.Wrap(err, "error acquiring lock")
+	}
+	if !locked {
+		if timeout > 0 {
+			return errors.Errorf("could not acquire lock within %v", timeout)
 		}
+		return errors.New("could not acquire lock")
 	}
+	emit(sink, &Event{Type: EventLockAcquired})
 	return nil
 }
 
+// findPending returns the migrations that have not yet been applied, in
+// the order they should be applied, stopping at opts.To if set. This
+// includes repeatable migrations that have changed since they were last
+// applied. It returns an error if any applied migrations have been
+// modified since, unless opts.AllowModified is set, in which case they are
+// returned as well.
+func findPending(ctx context.Context, migrations []*migration, client db.Driver, opts UpOptions) ([]*migration, []modification, error) {
+	applied, err := client.GetMigrations(ctx)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error fetching migrations")
+	}
+
+	// Refuse to run if applied migrations have been edited since, unless
+	// explicitly allowed.
+	modified, err := findModified(migrations, applied)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error reading migration")
+	}
+	if len(modified) > 0 && !opts.AllowModified {
+		names := make([]string, len(modified))
+		for i, mod := range modified {
+			names[i] = mod.migration.Name
+		}
+		return nil, nil, errors.Errorf("applied migrations have been modified: %s", strings.Join(names, ", "))
+	}
+
+	isApplied := func(m *migration) (bool, error) {
+		for _, a := range applied {
+			if a.Name != m.Name || a.Failed {
+				continue
+			}
+			// Repeatable migrations are applied again whenever they
+			// change.
+			if !m.Repeatable {
+				return true, nil
+			}
+			checksum, err := m.checksum()
+			if err != nil {
+				return false, errors.Wrap(err, "error reading migration")
+			}
+			return checksum == a.Checksum, nil
+		}
+		return false, nil
+	}
+	failure := func(name string) *db.Migration {
+		for _, a := range applied {
+			if a.Name == name && a.Failed {
+				return a
+			}
+		}
+		return nil
+	}
+
+	if opts.To != "" {
+		if migrations, err = truncateAt(migrations, opts.To); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var pending []*migration
+	for _, m := range migrations {
+		ok, err := isApplied(m)
+		if err != nil {
+			return nil, nil, err
+		}
+		if ok {
+			continue
+		}
+		// A migration that failed partway through can't safely be
+		// retried, since its earlier statements would run again.
+		if f := failure(m.Name); f != nil {
+			partial, err := isPartiallyApplied(m, f)
+			if err != nil {
+				return nil, nil, err
+			}
+			if partial {
+				return nil, nil, errors.Errorf(
+					"migration %s previously failed at statement %d, after partially applying; resolve it before retrying",
+					m.Name, f.FailedStatement+1,
+				)
+			}
+		}
+		pending = append(pending, m)
+	}
+	return pending, modified, nil
+}
+
+// isPartiallyApplied reports whether the failed migration left some of
+// its statements applied. Transactional migrations are rolled back when
+// they fail, so they never are.
+func isPartiallyApplied(m *migration, f *db.Migration) (bool, error) {
+	if f.FailedStatement == 0 {
+		return false, nil
+	}
+	directives, err := m.directives()
+	if err != nil {
+		return false, errors.Wrap(err, "error reading migration")
+	}
+	return !directives.Transaction, nil
+}
+
+// truncateAt returns the migrations up to and including the target,
+// which may be either a version or a name.
+func truncateAt(migrations []*migration, target string) ([]*migration, error) {
+	version, err := strconv.Atoi(target)
+	isVersion := err == nil
+	end := -1
+	for i, m := range migrations {
+		if m.Name != target && !(isVersion && !m.Repeatable && m.Version == version) {
+			continue
+		}
+		if end != -1 {
+			return nil, errors.Errorf("ambiguous target migration: %s", target)
+		}
+		end = i
+	}
+	if end == -1 {
+		return nil, errors.Errorf("target migration not found: %s", target)
+	}
+	return migrations[:end+1], nil
+}
+
 // prefixAll prefixes every line in the string.
 func prefixAll(prefix, stmt string) string {
 	ss := strings.Split(strings.TrimSpace(stmt), "\n")
@@ -0,0 +1,146 @@
+package migrate
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/jackc/pgconn"
+
+	"github.com/johngibb/migrate/db"
+	"github.com/johngibb/migrate/db/sqlite"
+	"github.com/johngibb/migrate/source"
+)
+
+func TestTruncateAt(t *testing.T) {
+	migrations := []*migration{
+		{Name: "1_first", Version: 1},
+		{Name: "2_second", Version: 2},
+		{Name: "3_third", Version: 3},
+	}
+	tests := []struct {
+		target string
+		want   []string
+		err    bool
+	}{
+		{target: "2", want: []string{"1_first", "2_second"}},
+		{target: "2_second", want: []string{"1_first", "2_second"}},
+		{target: "3_third", want: []string{"1_first", "2_second", "3_third"}},
+		{target: "4", err: true},
+		{target: "second", err: true},
+	}
+	for _, tt := range tests {
+		got, err := truncateAt(migrations, tt.target)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%s: want error, got nil", tt.target)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.target, err)
+			continue
+		}
+		var names []string
+		for _, m := range got {
+			names = append(names, m.Name)
+		}
+		if !reflect.DeepEqual(names, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.target, names, tt.want)
+		}
+	}
+}
+
+// lockingDriver is a SQLite driver that pretends to set timeouts, and
+// fails statements mentioning "locked" with a lock timeout, the given
+// number of times.
+type lockingDriver struct {
+	*sqlite.Client
+	failures int
+	timeouts []timeouts
+}
+
+func (d *lockingDriver) Exec(ctx context.Context, sql string) error {
+	if strings.Contains(sql, "locked") && d.failures > 0 {
+		d.failures--
+		return &pgconn.PgError{Code: "55P03", Message: "canceling statement due to lock timeout"}
+	}
+	return d.Client.Exec(ctx, sql)
+}
+
+func (d *lockingDriver) SetTimeouts(ctx context.Context, statement, lock time.Duration) error {
+	d.timeouts = append(d.timeouts, timeouts{statement, lock})
+	return nil
+}
+
+func TestLockRetries(t *testing.T) {
+	ctx := context.Background()
+	lite, err := sqlite.Open(ctx, "sqlite://"+filepath.Join(t.TempDir(), "test.db"), db.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lite.Close(ctx)
+	client := &lockingDriver{Client: lite, failures: 2}
+	fsys := fstest.MapFS{
+		"1_add_users.sql": {Data: []byte(`
+			-- migrate:transaction
+			-- migrate:pg-lock-timeout 2s
+			create table users(id int);
+			insert into users values (1);
+			select 'locked';
+		`)},
+	}
+	opts := UpOptions{
+		StatementTimeout: time.Minute,
+		PGLockTimeout:    time.Second,
+		LockRetries:      2,
+		LockRetryDelay:   time.Millisecond,
+	}
+
+	// The transaction is retried in full, and succeeds the third time.
+	events := &bufferedSink{}
+	opts.Events = events
+	if err := UpWithOptions(ctx, source.NewFS(fsys), client, opts); err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	if err := lite.DB().QueryRowContext(ctx, `select count(*) from users;`).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("got %d users, want 1", n)
+	}
+	var warnings int
+	for _, e := range events.events {
+		if e.Type == EventWarning {
+			warnings++
+		}
+	}
+	if warnings != 2 {
+		t.Errorf("got %d warnings, want 2", warnings)
+	}
+	// The directive overrides the lock timeout, and both are reset after.
+	want := []timeouts{{time.Minute, 2 * time.Second}, {}}
+	if !reflect.DeepEqual(client.timeouts, want) {
+		t.Errorf("timeouts: got %v, want %v", client.timeouts, want)
+	}
+
+	// A statement outside a transaction is retried on its own, and the
+	// migration fails once the retries run out.
+	client.failures = 3
+	fsys["2_add_alice.sql"] = &fstest.MapFile{Data: []byte(`insert into users values (2); select 'locked';`)}
+	err = UpWithOptions(ctx, source.NewFS(fsys), client, opts)
+	if !db.IsLockTimeout(err) {
+		t.Fatalf("got %v, want lock timeout", err)
+	}
+	if err := lite.DB().QueryRowContext(ctx, `select count(*) from users;`).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("got %d users, want 2", n)
+	}
+}